@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type batchItem struct {
+	Filename string `json:"filename"`
+	IngestID string `json:"ingest_id"`
+}
+
+type ingestBatch struct {
+	BatchID   string      `json:"batch_id"`
+	EnvID     string      `json:"env_id"`
+	Source    string      `json:"source"`
+	Directory string      `json:"directory,omitempty"`
+	CreatedAt string      `json:"created_at"`
+	Ingests   []batchItem `json:"ingests"`
+}
+
+type createBatchRequest struct {
+	Directory string `json:"directory"`
+}
+
+type batchItemStatus struct {
+	batchItem
+	Status        string `json:"status"`
+	Stage         string `json:"stage"`
+	QueuePosition int    `json:"queue_position,omitempty"`
+	Result        string `json:"result,omitempty"`
+	ErrorCode     string `json:"error_code,omitempty"`
+}
+
+type batchStatusResponse struct {
+	ingestBatch
+	Status  string            `json:"status"`
+	Counts  map[string]int    `json:"counts"`
+	Ingests []batchItemStatus `json:"ingests"`
+}
+
+// batchFile is one archive in a batch: an uploaded part spooled under
+// runtime/uploads, or a directory entry read in place. Either way it is
+// scanned on the environment queue when its turn comes.
+type batchFile struct {
+	st      *ingestStatus
+	path    string
+	recvErr *scanError
+}
+
+// isBatchArchiveName reports whether a directory entry is a TSF archive.
+func isBatchArchiveName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz")
+}
+
+// handleCreateBatch accepts several multipart `file` parts or a JSON
+// {"directory": ...} naming a local folder of .tgz/.tar.gz files, and
+// ingests them sorted ascending by filename (D-00012). Uploaded parts are
+// only spooled during the request; all scanning happens on the queue, so
+// the batch_id comes back as soon as the upload has been received.
+func (a *app) handleCreateBatch(w http.ResponseWriter, r *http.Request, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	batch := &ingestBatch{
+		BatchID:   newUUID(),
+		EnvID:     envID,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	var files []*batchFile
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		batch.Source = "upload"
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "invalid multipart upload")
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "invalid multipart upload")
+				return
+			}
+			if part.FormName() != "file" {
+				_ = part.Close()
+				continue
+			}
+			st := newIngestStatus(envID, part.FileName(), "batch")
+			a.storeIngest(st)
+			upload, recvErr := a.spoolUpload(st, part)
+			_ = part.Close()
+			files = append(files, &batchFile{st: st, path: upload, recvErr: recvErr})
+		}
+		if len(files) == 0 {
+			writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "at least one file field is required")
+			return
+		}
+	} else {
+		batch.Source = "directory"
+		defer r.Body.Close()
+		var req createBatchRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid JSON body")
+			return
+		}
+		dir := strings.TrimSpace(req.Directory)
+		if dir == "" || !filepath.IsAbs(dir) {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "directory must be an absolute path")
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "directory is not readable")
+			return
+		}
+		batch.Directory = filepath.Clean(dir)
+		for _, e := range entries {
+			if e.IsDir() || !isBatchArchiveName(e.Name()) {
+				continue
+			}
+			st := newIngestStatus(envID, e.Name(), "batch")
+			a.storeIngest(st)
+			files = append(files, &batchFile{st: st, path: filepath.Join(dir, e.Name())})
+		}
+		if len(files) == 0 {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "directory contains no .tgz or .tar.gz files")
+			return
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].st.Filename < files[j].st.Filename })
+	for _, f := range files {
+		batch.Ingests = append(batch.Ingests, batchItem{Filename: f.st.Filename, IngestID: f.st.IngestID})
+	}
+	if err := a.writeBatch(batch); err != nil {
+		for _, f := range files {
+			a.failScan(envDir, f.st, &scanError{Stage: "receive", Code: "ERR_PERSIST_FAILED", Msg: "failed to record batch"})
+		}
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to record batch")
+		return
+	}
+	a.mu.Lock()
+	a.batches[batch.BatchID] = batch
+	a.mu.Unlock()
+
+	for _, f := range files {
+		a.enqueueIngest(&ingestJob{envDir: envDir, st: f.st, upload: f.path, scanErr: f.recvErr})
+	}
+	writeJSON(w, http.StatusAccepted, batch)
+}
+
+func (a *app) batchPath(batchID string) string {
+	return filepath.Join(a.storage, "runtime", "batches", batchID+".json")
+}
+
+// writeBatch persists the batch record so GET /api/batches/{id} keeps
+// working after a restart; the ingests themselves are indexed from
+// ingest.ndjson.
+func (a *app) writeBatch(batch *ingestBatch) error {
+	b, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := a.batchPath(batch.BatchID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// getBatch returns a batch from memory, falling back to its runtime record.
+func (a *app) getBatch(batchID string) (*ingestBatch, bool) {
+	a.mu.RLock()
+	batch, ok := a.batches[batchID]
+	a.mu.RUnlock()
+	if ok {
+		return batch, true
+	}
+	b, err := os.ReadFile(a.batchPath(batchID))
+	if err != nil {
+		return nil, false
+	}
+	batch = &ingestBatch{}
+	if json.Unmarshal(b, batch) != nil || batch.BatchID != batchID {
+		return nil, false
+	}
+	a.mu.Lock()
+	a.batches[batchID] = batch
+	a.mu.Unlock()
+	return batch, true
+}
+
+func (a *app) handleGetBatch(w http.ResponseWriter, batchID string) {
+	batch, ok := a.getBatch(batchID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_BATCH_NOT_FOUND", "batch not found")
+		return
+	}
+
+	resp := batchStatusResponse{
+		ingestBatch: *batch,
+		Counts:      map[string]int{"total": len(batch.Ingests)},
+		Ingests:     make([]batchItemStatus, 0, len(batch.Ingests)),
+	}
+	for _, item := range batch.Ingests {
+		row := batchItemStatus{batchItem: item, Status: "unknown", Stage: "not_found"}
+		if st, ok := a.getIngest(item.IngestID); ok {
+			row.Status = st.Status
+			row.Stage = st.Stage
+			row.QueuePosition = st.QueuePosition
+			if st.FinalRecord != nil {
+				row.Result = valueString(st.FinalRecord["status"], "")
+				ingestErr, _ := st.FinalRecord["error"].(map[string]any)
+				row.ErrorCode = valueString(ingestErr["code"], "")
+			}
+		}
+		if row.Result != "" {
+			resp.Counts[row.Result]++
+		} else {
+			resp.Counts[row.Status]++
+		}
+		resp.Ingests = append(resp.Ingests, row)
+	}
+	switch {
+	case resp.Counts["queued"] > 0 || resp.Counts["running"] > 0:
+		resp.Status = "running"
+	case resp.Counts["awaiting_user"] > 0:
+		resp.Status = "awaiting_user"
+	default:
+		resp.Status = "completed"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
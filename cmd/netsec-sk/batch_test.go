@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func postBatch(a *app, envID, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/environments/"+envID+"/batches", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	a.handleCreateBatch(rec, req, envID)
+	return rec
+}
+
+func TestCreateBatchDirectoryValidation(t *testing.T) {
+	a := newTestApp(t)
+	envID, _ := newTestEnvDir(t, a)
+	noArchives := t.TempDir()
+	if err := os.WriteFile(filepath.Join(noArchives, "notes.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		envID    string
+		body     string
+		wantCode int
+		wantErr  string
+		wantMsg  string
+	}{
+		{name: "not JSON", envID: envID, body: "{", wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "invalid JSON body"},
+		{name: "missing directory", envID: envID, body: `{}`, wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "directory must be an absolute path"},
+		{name: "relative directory", envID: envID, body: `{"directory":"tsf/incoming"}`, wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "directory must be an absolute path"},
+		{name: "parent-relative directory", envID: envID, body: `{"directory":"../tsf"}`, wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "directory must be an absolute path"},
+		{name: "directory does not exist", envID: envID, body: `{"directory":"` + filepath.Join(noArchives, "missing") + `"}`, wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "directory is not readable"},
+		{name: "no archives", envID: envID, body: `{"directory":"` + noArchives + `"}`, wantCode: http.StatusBadRequest, wantErr: "ERR_BAD_REQUEST", wantMsg: "directory contains no .tgz or .tar.gz files"},
+		{name: "unknown environment", envID: newUUID(), body: `{"directory":"` + noArchives + `"}`, wantCode: http.StatusNotFound, wantErr: "ERR_ENV_NOT_FOUND"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := postBatch(a, tc.envID, tc.body)
+			var got errorResponse
+			_ = json.Unmarshal(rec.Body.Bytes(), &got)
+			if rec.Code != tc.wantCode || got.Code != tc.wantErr || (tc.wantMsg != "" && got.Message != tc.wantMsg) {
+				t.Errorf("create batch = %d %s %q, want %d %s %q", rec.Code, got.Code, got.Message, tc.wantCode, tc.wantErr, tc.wantMsg)
+			}
+		})
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.batches) != 0 || len(a.ingests) != 0 {
+		t.Errorf("rejected batches left %d batches and %d ingests", len(a.batches), len(a.ingests))
+	}
+}
+
+func TestCreateBatchDirectoryOrder(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	dir := t.TempDir()
+	for _, name := range []string{"b.tgz", "a.TAR.GZ", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("not gzip"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "c.tgz"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postBatch(a, envID, `{"directory":"`+dir+`/"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("create batch = %d %s", rec.Code, rec.Body.String())
+	}
+	var batch ingestBatch
+	if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Source != "directory" || batch.Directory != dir {
+		t.Errorf("batch source, directory = %s, %s; want directory, %s", batch.Source, batch.Directory, dir)
+	}
+	var names, ids, want []string
+	for _, item := range batch.Ingests {
+		names = append(names, item.Filename)
+		ids = append(ids, item.IngestID)
+		want = append(want, item.IngestID+" ERR_INVALID_ARCHIVE")
+	}
+	if !reflect.DeepEqual(names, []string{"a.TAR.GZ", "b.tgz"}) {
+		t.Fatalf("batch files = %q, want archives sorted by filename", names)
+	}
+
+	waitIngestsCompleted(t, a, ids...)
+	if got := ingestLog(t, envDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("ingest.ndjson = %q, want %q", got, want)
+	}
+	for _, name := range []string{"a.TAR.GZ", "b.tgz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("directory archive %s was not left in place: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	bundleFormat        = "netsec-sk-environment"
+	bundleFormatVersion = 1
+	bundleManifestPath  = "manifest.json"
+	// maxBundleBytes caps the uncompressed contents of an imported bundle.
+	maxBundleBytes int64 = 1 << 30
+)
+
+// bundleTopLevelFiles are exported when present; meta.json is required.
+var bundleTopLevelFiles = []string{"meta.json", "state.json", "intro.md", "commits.ndjson", "ingest.ndjson"}
+
+var (
+	bundleSnapshotPath = regexp.MustCompile(`^snapshots/([0-9a-f]{64})\.json$`)
+	bundleCommitPath   = regexp.MustCompile(`^commits/[0-9a-f-]{36}\.json$`)
+	// bundleEnvID matches the canonical env IDs newUUID generates; the ID
+	// becomes a directory name on import.
+	bundleEnvID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+type bundleFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type bundleManifest struct {
+	Format        string       `json:"format"`
+	FormatVersion int          `json:"format_version"`
+	EnvID         string       `json:"env_id"`
+	ExportedAt    string       `json:"exported_at"`
+	AppVersion    string       `json:"app_version"`
+	Files         []bundleFile `json:"files"`
+}
+
+func bundlePathAllowed(p string) bool {
+	for _, f := range bundleTopLevelFiles {
+		if p == f {
+			return true
+		}
+	}
+	return bundleSnapshotPath.MatchString(p) || bundleCommitPath.MatchString(p)
+}
+
+// collectBundleFiles reads the exportable files of an environment, keyed by
+// slash-separated path relative to the environment directory.
+func collectBundleFiles(envDir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, name := range bundleTopLevelFiles {
+		b, err := os.ReadFile(filepath.Join(envDir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		files[name] = b
+	}
+	for _, sub := range []string{"commits", "snapshots"} {
+		entries, err := os.ReadDir(filepath.Join(envDir, sub))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, e := range entries {
+			p := sub + "/" + e.Name()
+			if e.IsDir() || !bundlePathAllowed(p) {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(envDir, sub, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			files[p] = b
+		}
+	}
+	return files, nil
+}
+
+// handleExportEnvironment streams the environment as a tar.gz whose first
+// member is a manifest listing every other member with its SHA-256.
+func (a *app) handleExportEnvironment(w http.ResponseWriter, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+	// Hold the queue only while reading so the bundle is a consistent
+	// point-in-time copy; streaming happens after release.
+	if !a.claimEnvQueue(envID) {
+		writeError(w, http.StatusConflict, "ERR_ENV_BUSY", "environment has ingests in progress")
+		return
+	}
+	files, err := collectBundleFiles(envDir)
+	a.releaseEnvQueue(envID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environment files")
+		return
+	}
+	if _, ok := files["meta.json"]; !ok {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "environment metadata is missing")
+		return
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	manifest := bundleManifest{
+		Format:        bundleFormat,
+		FormatVersion: bundleFormatVersion,
+		EnvID:         envID,
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		AppVersion:    appVersion,
+		Files:         make([]bundleFile, 0, len(paths)),
+	}
+	for _, p := range paths {
+		sum := sha256.Sum256(files[p])
+		manifest.Files = append(manifest.Files, bundleFile{Path: p, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(files[p]))})
+	}
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to build manifest")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"netsec-sk-%s.tar.gz\"", envID))
+	w.WriteHeader(http.StatusOK)
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := time.Now().UTC()
+	writeMember := func(name string, b []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(b)), ModTime: modTime, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(b)
+		return err
+	}
+	if err := writeMember(bundleManifestPath, append(manifestBytes, '\n')); err != nil {
+		return
+	}
+	for _, p := range paths {
+		if err := writeMember(p, files[p]); err != nil {
+			return
+		}
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+}
+
+// readBundle extracts a bundle into memory and verifies it against its
+// manifest: every member must be listed with a matching SHA-256 and size,
+// every listed file must be present, and snapshot names must match their
+// content hash.
+func readBundle(src io.Reader) (*bundleManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, nil, errors.New("bundle is not a readable gzip/tar")
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.New("bundle is not a readable gzip/tar")
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if hdr.Typeflag != tar.TypeReg || (name != bundleManifestPath && !bundlePathAllowed(name)) {
+			return nil, nil, fmt.Errorf("unexpected bundle member %q", hdr.Name)
+		}
+		if _, dup := files[name]; dup {
+			return nil, nil, fmt.Errorf("duplicate bundle member %q", name)
+		}
+		total += hdr.Size
+		if total > maxBundleBytes {
+			return nil, nil, errors.New("bundle exceeds the import size cap")
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, nil, errors.New("bundle is not a readable gzip/tar")
+		}
+		files[name] = b
+	}
+
+	raw, ok := files[bundleManifestPath]
+	if !ok {
+		return nil, nil, errors.New("bundle manifest is missing")
+	}
+	delete(files, bundleManifestPath)
+	var manifest bundleManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, nil, errors.New("bundle manifest is invalid")
+	}
+	if manifest.Format != bundleFormat || manifest.FormatVersion != bundleFormatVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle format %q version %d", manifest.Format, manifest.FormatVersion)
+	}
+	if !bundleEnvID.MatchString(manifest.EnvID) {
+		return nil, nil, fmt.Errorf("bundle env_id %q is not a UUID", manifest.EnvID)
+	}
+	listed := map[string]bool{}
+	for _, f := range manifest.Files {
+		b, ok := files[f.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("bundle is missing %s", f.Path)
+		}
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != f.SHA256 || int64(len(b)) != f.Size {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", f.Path)
+		}
+		if m := bundleSnapshotPath.FindStringSubmatch(f.Path); m != nil && m[1] != f.SHA256 {
+			return nil, nil, fmt.Errorf("snapshot %s does not match its content hash", f.Path)
+		}
+		listed[f.Path] = true
+	}
+	for p := range files {
+		if !listed[p] {
+			return nil, nil, fmt.Errorf("bundle member %s is not in the manifest", p)
+		}
+	}
+	if _, ok := files["meta.json"]; !ok {
+		return nil, nil, errors.New("bundle is missing meta.json")
+	}
+	return &manifest, files, nil
+}
+
+// handleImportEnvironment creates an environment from an exported bundle
+// uploaded as the multipart "file" field. The environment gets a new ID
+// unless keep_id=true, in which case the original ID must be unused. The
+// bundled name is kept unless overridden with ?name=; either way it must
+// not clash with a live environment.
+func (a *app) handleImportEnvironment(w http.ResponseWriter, r *http.Request) {
+	keepID := r.URL.Query().Get("keep_id") == "true"
+	nameOverride, hasName := r.URL.Query()["name"]
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "invalid multipart upload")
+		return
+	}
+	var part *multipart.Part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "invalid multipart upload")
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+		_ = p.Close()
+	}
+	if part == nil {
+		writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "file field is required")
+		return
+	}
+	defer part.Close()
+
+	manifest, files, err := readBundle(part)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", err.Error())
+		return
+	}
+	var meta envMeta
+	if err := json.Unmarshal(files["meta.json"], &meta); err != nil || meta.EnvID != manifest.EnvID {
+		writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "bundle meta.json does not match the manifest")
+		return
+	}
+	if err := validateLabels(meta.Labels); err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "bundle meta.json labels are invalid: "+err.Error())
+		return
+	}
+	if b, ok := files["state.json"]; ok {
+		state, _, err := migrateStateDoc(b)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "bundle state.json is invalid: "+err.Error())
+			return
+		}
+		if state.Env.EnvID != manifest.EnvID {
+			writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "bundle state.json belongs to a different environment")
+			return
+		}
+		if err := validateState(state); err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BUNDLE_INVALID", "bundle "+err.Error())
+			return
+		}
+	}
+
+	a.metaMu.Lock()
+	defer a.metaMu.Unlock()
+	targetID := newUUID()
+	if keepID {
+		targetID = manifest.EnvID
+		if a.envIDInUse(targetID) {
+			writeError(w, http.StatusConflict, "ERR_ENV_EXISTS", "an environment with this ID already exists")
+			return
+		}
+	}
+	if hasName {
+		meta.Name = nameOverride[0]
+	}
+	name, nameErr := a.checkEnvName(meta.Name, "")
+	if nameErr != nil {
+		writeError(w, nameErr.Status, nameErr.Code, nameErr.Msg)
+		return
+	}
+	meta.Name = name
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	meta.EnvID = targetID
+	meta.SoftDeleted = false
+	meta.SoftDeletedAt = ""
+	var rehome map[string]any
+	if !keepID {
+		meta.CreatedAt = now
+		meta.UpdatedAt = now
+		rehome = map[string]any{
+			"source_summary": "import from environment " + manifest.EnvID,
+			"imported_from":  manifest.EnvID,
+		}
+	}
+	if err := a.installEnvironment(meta, files, rehome); err != nil {
+		writePersistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+// installEnvironment creates environments/<meta.EnvID> from copied files.
+// It assembles the directory under runtime/staging and moves it into place
+// only once complete. When rehome is non-nil the copied state is rewritten
+// to the new env ID via reassignStateEnvID, with rehome's fields added to
+// the recorded commit, and the copied ingest log gets fresh ingest IDs.
+func (a *app) installEnvironment(meta envMeta, files map[string][]byte, rehome map[string]any) error {
+	stageDir := filepath.Join(a.storage, "runtime", "staging", meta.EnvID)
+	_ = os.RemoveAll(stageDir)
+	defer os.RemoveAll(stageDir)
+	for p, b := range files {
+		if p == "ingest.ndjson" && rehome != nil {
+			b = rehomeIngestLog(b, meta.EnvID)
+		}
+		dst := filepath.Join(stageDir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, b, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := writeMeta(filepath.Join(stageDir, "meta.json"), meta); err != nil {
+		return err
+	}
+	if _, ok := files["state.json"]; ok && rehome != nil {
+		if err := a.reassignStateEnvID(stageDir, rehome); err != nil {
+			return err
+		}
+	}
+
+	envDir := filepath.Join(a.storage, "environments", meta.EnvID)
+	if err := os.MkdirAll(filepath.Dir(envDir), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(stageDir, envDir)
+}
+
+// rehomeIngestLog gives every record of a copied ingest.ndjson a new
+// ingest_id and the new env_id, keeping the source ID as
+// original_ingest_id. Ingest IDs are global, so records shared with the
+// source environment would collide in the index rebuilt on startup.
+// Commits and snapshots keep the original IDs: they describe history that
+// happened in the source environment and their hashes must not change.
+func rehomeIngestLog(log []byte, envID string) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(log), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var rec map[string]any
+		if json.Unmarshal([]byte(line), &rec) != nil {
+			out.WriteString(line + "\n")
+			continue
+		}
+		if id := valueString(rec["ingest_id"], ""); id != "" {
+			rec["original_ingest_id"] = id
+			rec["ingest_id"] = newUUID()
+		}
+		rec["env_id"] = envID
+		b, err := json.Marshal(rec)
+		if err != nil {
+			out.WriteString(line + "\n")
+			continue
+		}
+		out.Write(b)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// envIDInUse reports whether envID names a live, trashed or still-staging
+// environment.
+func (a *app) envIDInUse(envID string) bool {
+	if _, status := a.resolveEnvironmentPath(envID); status != http.StatusNotFound {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(a.storage, "runtime", "staging", envID))
+	return err == nil
+}
+
+// reassignStateEnvID rewrites state.env.env_id for an environment whose
+// files were copied under a new ID, recording the change as a commit so the
+// copied history still hashes to the states it describes. fields supplies
+// the commit's source_summary and a reference to the source environment.
+func (a *app) reassignStateEnvID(envDir string, fields map[string]any) error {
+	state, err := a.loadState(envDir)
+	if err != nil {
+		return err
+	}
+	beforeHash, _ := hashCanonical(state)
+	beforeDoc, _ := canonicalCopy(state)
+	envID := filepath.Base(envDir)
+	state.Env.EnvID = envID
+	afterHash, _ := hashCanonical(state)
+	if err := a.writeStateAtomic(envDir, state); err != nil {
+		return err
+	}
+	_, _ = writeStateSnapshot(envDir, beforeDoc)
+	afterDoc, _ := canonicalCopy(state)
+	commit := map[string]any{
+		"commit_id":         newUUID(),
+		"env_id":            envID,
+		"ingest_id":         "not_found",
+		"timestamp":         time.Now().UTC().Format(time.RFC3339),
+		"state_hash_before": beforeHash,
+		"state_hash_after":  afterHash,
+	}
+	for k, v := range fields {
+		commit[k] = v
+	}
+	if err := recordStateCommit(envDir, commit, beforeDoc, afterDoc); err != nil {
+		return err
+	}
+	_ = os.Remove(filepath.Join(envDir, "state.json.bak"))
+
+	a.refreshIntro(envDir, state)
+	return nil
+}
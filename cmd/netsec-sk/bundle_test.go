@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testBundleEnvID = "0b7c6f1e-8d7a-4f4e-9a51-3c2d1e0f9a8b"
+
+type bundleMember struct {
+	name string
+	body []byte
+}
+
+// bundleManifestFor lists files the way handleExportEnvironment does.
+func bundleManifestFor(files map[string][]byte) bundleManifest {
+	m := bundleManifest{Format: bundleFormat, FormatVersion: bundleFormatVersion, EnvID: testBundleEnvID}
+	for p, b := range files {
+		sum := sha256.Sum256(b)
+		m.Files = append(m.Files, bundleFile{Path: p, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(b))})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	return m
+}
+
+func writeTestBundle(t *testing.T, members []bundleMember) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0o644, Size: int64(len(m.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadBundle(t *testing.T) {
+	snapshot := []byte(`{"schema_version":"1.1.0"}`)
+	sum := sha256.Sum256(snapshot)
+	snapshotPath := "snapshots/" + hex.EncodeToString(sum[:]) + ".json"
+	files := map[string][]byte{
+		"meta.json":     []byte(`{"env_id":"` + testBundleEnvID + `","name":"lab"}`),
+		"state.json":    []byte(`{}`),
+		snapshotPath:    snapshot,
+		"intro.md":      []byte("# lab\n"),
+		"ingest.ndjson": []byte(`{"ingest_id":"i1"}` + "\n"),
+	}
+
+	// build encodes manifest followed by every file, after edit has had a
+	// chance to tamper with either.
+	build := func(edit func(m *bundleManifest, members map[string][]byte)) []byte {
+		members := map[string][]byte{}
+		for p, b := range files {
+			members[p] = append([]byte(nil), b...)
+		}
+		m := bundleManifestFor(files)
+		if edit != nil {
+			edit(&m, members)
+		}
+		raw, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := []bundleMember{{bundleManifestPath, raw}}
+		paths := make([]string, 0, len(members))
+		for p := range members {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			list = append(list, bundleMember{p, members[p]})
+		}
+		return writeTestBundle(t, list)
+	}
+
+	tests := []struct {
+		name    string
+		bundle  []byte
+		wantErr string
+	}{
+		{name: "valid", bundle: build(nil)},
+		{
+			name: "tampered member",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				members["state.json"] = []byte(`{"x"}`)
+			}),
+			wantErr: "checksum mismatch for state.json",
+		},
+		{
+			name: "size does not match manifest",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				for i := range m.Files {
+					if m.Files[i].Path == "intro.md" {
+						m.Files[i].Size++
+					}
+				}
+			}),
+			wantErr: "checksum mismatch for intro.md",
+		},
+		{
+			name: "listed member missing",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				delete(members, "intro.md")
+			}),
+			wantErr: "bundle is missing intro.md",
+		},
+		{
+			name: "member not in manifest",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				members["commits.ndjson"] = []byte("\n")
+			}),
+			wantErr: "bundle member commits.ndjson is not in the manifest",
+		},
+		{
+			name: "snapshot name does not match its content",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				other := []byte(`{"schema_version":"1.0.0"}`)
+				members[snapshotPath] = other
+				for i := range m.Files {
+					if m.Files[i].Path == snapshotPath {
+						s := sha256.Sum256(other)
+						m.Files[i].SHA256 = hex.EncodeToString(s[:])
+						m.Files[i].Size = int64(len(other))
+					}
+				}
+			}),
+			wantErr: "does not match its content hash",
+		},
+		{
+			name: "meta.json missing",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				delete(members, "meta.json")
+				*m = bundleManifestFor(members)
+			}),
+			wantErr: "bundle is missing meta.json",
+		},
+		{
+			name: "unsupported format version",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				m.FormatVersion = 2
+			}),
+			wantErr: "unsupported bundle format",
+		},
+		{
+			name: "env_id escapes the storage root",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				m.EnvID = "../../../escaped-env"
+			}),
+			wantErr: `bundle env_id "../../../escaped-env" is not a UUID`,
+		},
+		{
+			name: "env_id in upper case",
+			bundle: build(func(m *bundleManifest, members map[string][]byte) {
+				m.EnvID = strings.ToUpper(testBundleEnvID)
+			}),
+			wantErr: "is not a UUID",
+		},
+		{
+			name:    "manifest missing",
+			bundle:  writeTestBundle(t, []bundleMember{{"meta.json", files["meta.json"]}}),
+			wantErr: "bundle manifest is missing",
+		},
+		{
+			name:    "manifest is not JSON",
+			bundle:  writeTestBundle(t, []bundleMember{{bundleManifestPath, []byte("{")}}),
+			wantErr: "bundle manifest is invalid",
+		},
+		{
+			name:    "path outside the bundle layout",
+			bundle:  writeTestBundle(t, []bundleMember{{"../meta.json", files["meta.json"]}}),
+			wantErr: "unexpected bundle member",
+		},
+		{
+			name:    "duplicate member",
+			bundle:  writeTestBundle(t, []bundleMember{{"intro.md", nil}, {"./intro.md", nil}}),
+			wantErr: "duplicate bundle member",
+		},
+		{name: "not gzip", bundle: []byte("meta.json"), wantErr: "not a readable gzip/tar"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			manifest, got, err := readBundle(bytes.NewReader(tc.bundle))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("readBundle error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readBundle: %v", err)
+			}
+			if manifest.EnvID != testBundleEnvID || len(got) != len(files) {
+				t.Errorf("readBundle = %s with %d files, want %s with %d", manifest.EnvID, len(got), testBundleEnvID, len(files))
+			}
+			for p, b := range files {
+				if !bytes.Equal(got[p], b) {
+					t.Errorf("%s = %q, want %q", p, got[p], b)
+				}
+			}
+		})
+	}
+}
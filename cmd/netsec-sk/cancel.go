@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+)
+
+// abortIfCanceled is checked at every stage boundary of processIngest; once
+// the ingest's context is canceled it writes the ERR_USER_ABORTED record for
+// the stage it stopped in.
+func (a *app) abortIfCanceled(envDir string, st *ingestStatus, extracted map[string]any) bool {
+	if st.ctx == nil || st.ctx.Err() == nil {
+		return false
+	}
+	a.abortIngest(envDir, st, extracted)
+	return true
+}
+
+func (a *app) abortIngest(envDir string, st *ingestStatus, extracted map[string]any) {
+	final := finalizeRecord(st, "error", map[string]any{
+		"stage":   st.Stage,
+		"code":    "ERR_USER_ABORTED",
+		"message": "ingest canceled by user",
+	})
+	if extracted != nil {
+		populateDeviceFromExtracted(final, extracted)
+	}
+	if st.PendingData != nil {
+		addRMARecord(final, true, "canceled")
+	}
+	a.completeIngest(envDir, st, final, "completed with user abort")
+}
+
+// handleCancelIngest serves DELETE /api/ingests/{id} and
+// POST /api/ingests/{id}/cancel. Queued and paused ingests are finished
+// here; a running ingest is signaled and stops at its next stage boundary,
+// so the response reports it as "canceling".
+func (a *app) handleCancelIngest(w http.ResponseWriter, ingestID string) {
+	st, ok := a.getIngest(ingestID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_INGEST_NOT_FOUND", "ingest not found")
+		return
+	}
+	if st.Status == "completed" {
+		writeError(w, http.StatusConflict, "ERR_INGEST_COMPLETED", "ingest already completed")
+		return
+	}
+
+	envDir := filepath.Join(a.storage, "environments", st.EnvID)
+	switch st.Status {
+	case "queued":
+		if job := a.removeQueuedIngest(st.EnvID, ingestID); job != nil {
+			a.abortIngest(job.envDir, job.st, job.st.Extracted)
+			writeJSON(w, http.StatusOK, map[string]any{"ingest_id": ingestID, "status": "completed"})
+			return
+		}
+	case "awaiting_user":
+		// A decision may be claiming the same ingest; whichever claims it
+		// first finalizes it.
+		if paused, ok := a.claimPausedIngest(ingestID); ok {
+			a.abortIngest(envDir, paused, paused.Extracted)
+			writeJSON(w, http.StatusOK, map[string]any{"ingest_id": ingestID, "status": "completed"})
+			return
+		}
+		if st, _ = a.getIngest(ingestID); st.Status == "completed" {
+			writeError(w, http.StatusConflict, "ERR_INGEST_COMPLETED", "ingest already completed")
+			return
+		}
+	}
+	if st.cancel != nil {
+		st.cancel()
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"ingest_id": ingestID, "status": "canceling"})
+}
+
+// removeQueuedIngest takes a waiting job off its environment queue so the
+// caller owns it exclusively.
+func (a *app) removeQueuedIngest(envID, ingestID string) *ingestJob {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	q := a.queues[envID]
+	if q == nil {
+		return nil
+	}
+	for i, job := range q.jobs {
+		if job.st.IngestID != ingestID {
+			continue
+		}
+		q.jobs = append(q.jobs[:i:i], q.jobs[i+1:]...)
+		job.st.QueuePosition = 0
+		a.publishQueuePositions(q)
+		return job
+	}
+	return nil
+}
+
+// claimPausedIngest takes ownership of an ingest waiting on an RMA prompt.
+// Nothing else owns a paused ingest, so a decision and a cancel arriving
+// together both go through here and exactly one of them gets it; the winner
+// sees the status move off awaiting_user under queueMu.
+func (a *app) claimPausedIngest(ingestID string) (*ingestStatus, bool) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	st, ok := a.getIngest(ingestID)
+	if !ok || st.Status != "awaiting_user" {
+		return nil, false
+	}
+	st.Status = "queued"
+	a.storeIngest(&st)
+	return &st, true
+}
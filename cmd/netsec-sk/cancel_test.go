@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// cancelIngest calls the cancel handler and returns its status and body.
+func cancelIngest(t *testing.T, a *app, ingestID string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	a.handleCancelIngest(rec, ingestID)
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("cancel response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body
+}
+
+// pausedIngest stores an ingest waiting on an RMA prompt.
+func pausedIngest(a *app, envID string) *ingestStatus {
+	st := newIngestStatus(envID, "tsf.tgz", "upload")
+	st.Status = "awaiting_user"
+	st.Stage = "awaiting_user"
+	st.RMAPrompt = map[string]any{"serial": "0079001"}
+	st.PendingData = map[string]any{}
+	a.storeIngest(st)
+	return st
+}
+
+func TestCancelQueuedIngest(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	a.claimEnvQueue(envID)
+	first := failingJob(envID, envDir, "a.tgz")
+	second := failingJob(envID, envDir, "b.tgz")
+	for _, job := range []*ingestJob{first, second} {
+		a.storeIngest(job.st)
+		a.enqueueIngest(job)
+	}
+
+	code, body := cancelIngest(t, a, first.st.IngestID)
+	if code != http.StatusOK || body["status"] != "completed" {
+		t.Fatalf("cancel queued = %d %v, want 200 completed", code, body)
+	}
+	if st, _ := a.getIngest(second.st.IngestID); st.QueuePosition != 1 {
+		t.Errorf("remaining ingest queue_position = %d, want 1", st.QueuePosition)
+	}
+	code, body = cancelIngest(t, a, first.st.IngestID)
+	if code != http.StatusConflict || body["code"] != "ERR_INGEST_COMPLETED" {
+		t.Errorf("second cancel = %d %v, want 409 ERR_INGEST_COMPLETED", code, body)
+	}
+
+	a.releaseEnvQueue(envID)
+	waitIngestsCompleted(t, a, second.st.IngestID)
+	want := []string{first.st.IngestID + " ERR_USER_ABORTED", second.st.IngestID + " ERR_INVALID_ARCHIVE"}
+	if got := ingestLog(t, envDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("ingest.ndjson = %q, want %q", got, want)
+	}
+}
+
+func TestCancelPausedIngest(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	st := pausedIngest(a, envID)
+
+	code, body := cancelIngest(t, a, st.IngestID)
+	if code != http.StatusOK || body["status"] != "completed" {
+		t.Fatalf("cancel paused = %d %v, want 200 completed", code, body)
+	}
+	if _, ok := a.claimPausedIngest(st.IngestID); ok {
+		t.Error("claimPausedIngest after cancel = true")
+	}
+	final, _ := a.getIngest(st.IngestID)
+	if rma := final.FinalRecord["rma"]; !reflect.DeepEqual(rma, map[string]any{"prompted": true, "decision": "canceled"}) {
+		t.Errorf("final rma = %v, want prompted and canceled", rma)
+	}
+	if got := ingestLog(t, envDir); !reflect.DeepEqual(got, []string{st.IngestID + " ERR_USER_ABORTED"}) {
+		t.Errorf("ingest.ndjson = %q", got)
+	}
+}
+
+// TestCancelAfterRMADecisionClaim covers a cancel landing after a decision
+// has claimed the paused ingest but before it runs: the cancel signals the
+// ingest and the queue finalizes it as aborted.
+func TestCancelAfterRMADecisionClaim(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	st := pausedIngest(a, envID)
+
+	paused, ok := a.claimPausedIngest(st.IngestID)
+	if !ok {
+		t.Fatal("claimPausedIngest = false")
+	}
+	if _, ok := a.claimPausedIngest(st.IngestID); ok {
+		t.Fatal("second claimPausedIngest = true, want exactly one owner")
+	}
+
+	code, body := cancelIngest(t, a, st.IngestID)
+	if code != http.StatusAccepted || body["status"] != "canceling" {
+		t.Fatalf("cancel = %d %v, want 202 canceling", code, body)
+	}
+	a.enqueueIngest(&ingestJob{envDir: envDir, st: paused, decision: map[string]any{"decision": "treat_as_new_device"}})
+	waitIngestsCompleted(t, a, st.IngestID)
+
+	if got := ingestLog(t, envDir); !reflect.DeepEqual(got, []string{st.IngestID + " ERR_USER_ABORTED"}) {
+		t.Errorf("ingest.ndjson = %q, want one aborted record", got)
+	}
+}
+
+func TestCancelUnknownIngest(t *testing.T) {
+	a := newTestApp(t)
+	code, body := cancelIngest(t, a, newUUID())
+	if code != http.StatusNotFound || body["code"] != "ERR_INGEST_NOT_FOUND" {
+		t.Errorf("cancel = %d %v, want 404 ERR_INGEST_NOT_FOUND", code, body)
+	}
+}
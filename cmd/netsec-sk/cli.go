@@ -0,0 +1,411 @@
+package main
+
+import (
+	"net/netip"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type cliSection struct {
+	Command    string
+	SourcePath string
+	Body       string
+}
+
+type cliSections []cliSection
+
+type cliFieldRule struct {
+	Section   string
+	Pattern   *regexp.Regexp
+	LocalOnly bool
+}
+
+var cliHeaderRe = regexp.MustCompile(`^[A-Za-z0-9._@()-]*>\s*((?:show|request|debug|less)\s.*?)\s*$`)
+
+func isCLIMember(name string) bool {
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !strings.HasPrefix(clean, "tmp/cli/") && !strings.Contains(clean, "/tmp/cli/") {
+		return false
+	}
+	ok, _ := path.Match("techsupport_*.txt", path.Base(clean))
+	return ok
+}
+
+func normalizeCLICommand(cmd string) string {
+	return strings.ToLower(strings.Join(strings.Fields(cmd), " "))
+}
+
+// find returns the first section whose command matches exactly, falling back
+// to the first section whose command starts with the requested one (e.g.
+// "show routing route type static" for "show routing route").
+func (s cliSections) find(command string) (cliSection, bool) {
+	command = normalizeCLICommand(command)
+	for _, sec := range s {
+		if sec.Command == command {
+			return sec, true
+		}
+	}
+	for _, sec := range s {
+		if strings.HasPrefix(sec.Command, command+" ") {
+			return sec, true
+		}
+	}
+	return cliSection{}, false
+}
+
+// extractCLIField applies rules in order and returns the first non-empty
+// capture along with the section command and member path it came from.
+func (s cliSections) extractCLIField(rules ...cliFieldRule) (string, string, string) {
+	for _, rule := range rules {
+		sec, ok := s.find(rule.Section)
+		if !ok {
+			continue
+		}
+		body := sec.Body
+		if rule.LocalOnly {
+			body = haLocalBlock(body)
+		}
+		m := rule.Pattern.FindStringSubmatch(body)
+		if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
+			return strings.TrimSpace(m[1]), sec.Command, sec.SourcePath
+		}
+	}
+	return "not_found", "not_found", "not_found"
+}
+
+// haLocalBlock trims `show high-availability all` output down to the local
+// device block so peer values are never picked up as local identity.
+func haLocalBlock(body string) string {
+	lower := strings.ToLower(body)
+	if i := strings.Index(lower, "peer information"); i >= 0 {
+		return body[:i]
+	}
+	return body
+}
+
+var cliIdentityRules = map[string][]cliFieldRule{
+	"hostname": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*hostname:\s*(.+?)\s*$`)},
+	},
+	"model": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*model:\s*(.+?)\s*$`)},
+		{Section: "show high-availability all", Pattern: regexp.MustCompile(`(?m)^\s*Model:\s*(.+?)\s*$`), LocalOnly: true},
+	},
+	"serial": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*serial:\s*(.+?)\s*$`)},
+		{Section: "show high-availability all", Pattern: regexp.MustCompile(`(?m)^\s*Serial:\s*(.+?)\s*$`), LocalOnly: true},
+	},
+	"panos_version": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*sw-version:\s*(.+?)\s*$`)},
+		{Section: "show high-availability all", Pattern: regexp.MustCompile(`(?m)^\s*Build Release:\s*(.+?)\s*$`), LocalOnly: true},
+	},
+	"mgmt_ip": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*ip-address:\s*(.+?)\s*$`)},
+		{Section: "show interface management", Pattern: regexp.MustCompile(`(?mi)^\s*Ip address:\s*(.+?)\s*$`)},
+	},
+	"cloud_mode": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*cloud-mode:\s*(.+?)\s*$`)},
+	},
+	"system_mode": {
+		{Section: "show system info", Pattern: regexp.MustCompile(`(?m)^\s*system-mode:\s*(.+?)\s*$`)},
+	},
+}
+
+// extractCLIIdentity resolves each identity field from its own CLI section
+// and returns the values plus a field -> {section, source_path} provenance map.
+func extractCLIIdentity(secs cliSections) (map[string]string, map[string]any) {
+	fields := make([]string, 0, len(cliIdentityRules))
+	for f := range cliIdentityRules {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	values := map[string]string{}
+	sources := map[string]any{}
+	for _, f := range fields {
+		v, sec, src := secs.extractCLIField(cliIdentityRules[f]...)
+		values[f] = v
+		sources[f] = map[string]any{"section": sec, "source_path": src}
+	}
+	return values, sources
+}
+
+var licenseFieldRes = map[string]*regexp.Regexp{
+	"feature":     regexp.MustCompile(`(?m)^\s*Feature:\s*(.+?)\s*$`),
+	"description": regexp.MustCompile(`(?m)^\s*Description:\s*(.+?)\s*$`),
+	"issued":      regexp.MustCompile(`(?m)^\s*Issued:\s*(.+?)\s*$`),
+	"expires":     regexp.MustCompile(`(?m)^\s*Expires:\s*(.+?)\s*$`),
+	"expired":     regexp.MustCompile(`(?mi)^\s*Expired\?:\s*(yes|no)\s*$`),
+}
+
+var licenseEntryRe = regexp.MustCompile(`(?m)^\s*License entry:\s*$`)
+
+// extractLicenses parses `request license info` into one record per
+// `License entry:` block, sorted by feature then expiry.
+func extractLicenses(secs cliSections) []map[string]any {
+	out := make([]map[string]any, 0)
+	sec, ok := secs.find("request license info")
+	if !ok {
+		return out
+	}
+	for _, block := range licenseEntryRe.Split(sec.Body, -1) {
+		get := func(field string) string {
+			m := licenseFieldRes[field].FindStringSubmatch(block)
+			if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
+				return strings.TrimSpace(m[1])
+			}
+			return "not_found"
+		}
+		feature := get("feature")
+		if feature == "not_found" {
+			continue
+		}
+		status := "unknown"
+		switch strings.ToLower(get("expired")) {
+		case "no":
+			status = "active"
+		case "yes":
+			status = "expired"
+		}
+		out = append(out, map[string]any{
+			"feature":     feature,
+			"description": get("description"),
+			"issued":      get("issued"),
+			"expires":     get("expires"),
+			"status":      status,
+			"source_path": sec.SourcePath,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		fi, fj := valueString(out[i]["feature"], ""), valueString(out[j]["feature"], "")
+		if fi != fj {
+			return fi < fj
+		}
+		return valueString(out[i]["expires"], "") < valueString(out[j]["expires"], "")
+	})
+	return out
+}
+
+var (
+	haModeRe     = regexp.MustCompile(`(?m)^\s*Mode:\s*(.+?)\s*$`)
+	haStateRe    = regexp.MustCompile(`(?m)^\s*State:\s*(.+?)\s*$`)
+	haSerialRe   = regexp.MustCompile(`(?m)^\s*Serial:\s*(.+?)\s*$`)
+	haPeerMgmtRe = regexp.MustCompile(`(?mi)^\s*(?:Management IPv4 Address|Mgmt IP Address|Management IP Address):\s*([0-9.]+)`)
+)
+
+// extractCLIHA parses `show high-availability all` into the operational HA
+// view; config values take precedence later in applyConfigExtraction. The
+// peer's management address goes to peer_mgmt_ip only: peer is the HA1
+// peer address, which comes from config.
+func extractCLIHA(secs cliSections) map[string]any {
+	ha := map[string]any{
+		"enabled":      "unknown",
+		"mode":         "not_found",
+		"peer":         "not_found",
+		"local_state":  "not_found",
+		"peer_state":   "not_found",
+		"peer_serial":  "not_found",
+		"peer_mgmt_ip": "not_found",
+		"source_path":  "not_found",
+	}
+	sec, ok := secs.find("show high-availability all")
+	if !ok {
+		return ha
+	}
+	local := haLocalBlock(sec.Body)
+	peer := strings.TrimPrefix(sec.Body, local)
+	get := func(body string, re *regexp.Regexp) string {
+		m := re.FindStringSubmatch(body)
+		if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
+			return strings.TrimSpace(m[1])
+		}
+		return "not_found"
+	}
+	state := func(v string) string {
+		if v == "not_found" {
+			return v
+		}
+		return strings.ToLower(strings.Fields(v)[0])
+	}
+
+	mode := get(local, haModeRe)
+	if mode != "not_found" {
+		ha["enabled"] = "enabled"
+		ha["mode"] = strings.ToLower(mode)
+	} else if strings.Contains(strings.ToLower(sec.Body), "not enabled") {
+		ha["enabled"] = "disabled"
+	}
+	ha["local_state"] = state(get(local, haStateRe))
+	if peer != "" {
+		ha["peer_state"] = state(get(peer, haStateRe))
+		ha["peer_serial"] = get(peer, haSerialRe)
+		if m := haPeerMgmtRe.FindStringSubmatch(peer); len(m) > 1 {
+			ha["peer_mgmt_ip"] = m[1]
+		}
+	}
+	ha["source_path"] = sec.SourcePath
+	return ha
+}
+
+var routeVRHeaderRe = regexp.MustCompile(`(?i)^\s*(?:virtual router|logical router|vrf):\s*(\S+)`)
+
+var routeColumns = []string{"destination", "nexthop", "metric", "flags", "age", "interface", "next-as"}
+
+// extractRuntimeRoutes parses `show routing route` (or the advanced-routing
+// `show advanced-routing route` form) into route records per VR.
+func extractRuntimeRoutes(secs cliSections) []map[string]any {
+	out := make([]map[string]any, 0)
+	sec, ok := secs.find("show routing route")
+	if !ok {
+		sec, ok = secs.find("show advanced-routing route")
+	}
+	if !ok {
+		return out
+	}
+
+	vr := "not_found"
+	var cols map[string]int
+	for _, line := range strings.Split(sec.Body, "\n") {
+		if m := routeVRHeaderRe.FindStringSubmatch(line); m != nil {
+			vr = m[1]
+			continue
+		}
+		lower := strings.ToLower(line)
+		if strings.HasPrefix(strings.TrimSpace(lower), "destination") && strings.Contains(lower, "nexthop") {
+			cols = map[string]int{}
+			for _, c := range routeColumns {
+				if i := strings.Index(lower, c); i >= 0 {
+					cols[c] = i
+				}
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pfx, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			continue
+		}
+		rec := routeLineColumns(line, cols)
+		if !routeColumnsAligned(rec, fields) {
+			rec = routeLineTokens(fields)
+		}
+		protocol, reason := routeProtocol(rec["flags"])
+		out = append(out, map[string]any{
+			"vr":          vr,
+			"destination": pfx.Masked().String(),
+			"nexthop":     valueString(rec["nexthop"], "not_found"),
+			"interface":   valueString(rec["interface"], "not_found"),
+			"metric":      valueString(rec["metric"], "not_found"),
+			"flags":       valueString(rec["flags"], "not_found"),
+			"protocol":    protocol,
+			"reason":      reason,
+			"source_type": "runtime",
+			"source_path": sec.SourcePath,
+			"provenance":  "runtime_cli",
+		})
+	}
+	sortRoutes(out)
+	return out
+}
+
+// routeLineColumns slices a route row by the header column offsets; it
+// returns nil when no usable header has been seen yet.
+func routeLineColumns(line string, cols map[string]int) map[string]string {
+	if cols == nil || len(cols) < 3 {
+		return nil
+	}
+	type col struct {
+		name  string
+		start int
+	}
+	ordered := make([]col, 0, len(cols))
+	for name, start := range cols {
+		ordered = append(ordered, col{name, start})
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].start < ordered[j].start })
+	out := map[string]string{}
+	for i, c := range ordered {
+		if c.start >= len(line) {
+			break
+		}
+		end := len(line)
+		if i+1 < len(ordered) && ordered[i+1].start < end {
+			end = ordered[i+1].start
+		}
+		out[c.name] = strings.TrimSpace(line[c.start:end])
+	}
+	return out
+}
+
+// routeColumnsAligned reports whether a column-sliced row is usable: the
+// destination must be the row's first token and every sliced value must be
+// made of whole tokens, so a row shifted against its header (e.g. "hernet1/2"
+// cut from "ethernet1/2") falls back to routeLineTokens.
+func routeColumnsAligned(rec map[string]string, fields []string) bool {
+	if rec == nil || rec["destination"] != fields[0] {
+		return false
+	}
+	tokens := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		tokens[f] = true
+	}
+	for _, v := range rec {
+		for _, f := range strings.Fields(v) {
+			if !tokens[f] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// routeLineTokens is the whitespace fallback for rows without a header:
+// destination, nexthop, metric, flags..., [age], interface.
+func routeLineTokens(fields []string) map[string]string {
+	out := map[string]string{"nexthop": fields[1]}
+	if len(fields) > 2 {
+		out["metric"] = fields[2]
+	}
+	if len(fields) > 3 {
+		last := fields[len(fields)-1]
+		if _, err := strconv.Atoi(last); err != nil {
+			out["interface"] = last
+			fields = fields[:len(fields)-1]
+		}
+		flags := make([]string, 0)
+		for _, f := range fields[3:] {
+			if _, err := strconv.Atoi(f); err == nil {
+				continue
+			}
+			flags = append(flags, f)
+		}
+		out["flags"] = strings.Join(flags, " ")
+	}
+	return out
+}
+
+func routeProtocol(flags string) (string, string) {
+	for _, f := range strings.Fields(strings.ReplaceAll(flags, "?", " ")) {
+		switch {
+		case f == "C":
+			return "connected", "connected"
+		case f == "H":
+			return "host", "connected"
+		case f == "S":
+			return "static", "static"
+		case f == "B":
+			return "bgp", "bgp"
+		case strings.HasPrefix(f, "O"):
+			return "ospf", "ospf"
+		case f == "R":
+			return "rip", "rip"
+		}
+	}
+	return "unknown", "unknown"
+}
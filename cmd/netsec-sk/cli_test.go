@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseCLIFixture splits inline techsupport text the way scanArchive does.
+func parseCLIFixture(t *testing.T, text string) cliSections {
+	t.Helper()
+	secs, warnings, err := parseCLIStream("tmp/cli/techsupport_1.txt", strings.NewReader(text), map[string]struct{}{})
+	if err != nil {
+		t.Fatalf("parseCLIStream: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Fatalf("parseCLIStream warnings: %q", warnings)
+	}
+	return secs
+}
+
+func TestParseCLIStream(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantCommands []string
+		wantBodies   map[string]string
+		wantManaged  []string
+	}{
+		{
+			name: "sections split on prompts and normalized",
+			input: "admin@fw1(active)> show  System Info\n" +
+				"hostname: fw1\n" +
+				"admin@fw1(active)> show clock\n" +
+				"Mon Jan  1\n" +
+				"admin@fw1(active)> request license info\r\n" +
+				"Feature: Threat Prevention\r\n",
+			wantCommands: []string{"show system info", "request license info"},
+			wantBodies: map[string]string{
+				"show system info":     "hostname: fw1\n",
+				"request license info": "Feature: Threat Prevention\n",
+			},
+		},
+		{
+			name:         "prefix of a section of interest is kept",
+			input:        "fw1> show routing route type static\n10.0.0.0/8 10.1.1.1 10 A S ethernet1/1\n",
+			wantCommands: []string{"show routing route type static"},
+		},
+		{
+			name:         "text before the first prompt is ignored",
+			input:        "banner\nhostname: ghost\nfw1> show system info\nhostname: fw1\n",
+			wantCommands: []string{"show system info"},
+			wantBodies:   map[string]string{"show system info": "hostname: fw1\n"},
+		},
+		{
+			name:         "managed serials are collected from skipped sections",
+			input:        "pano> show devices connected\nmanaged_serial: 0079001\nmanaged serial = 0079002\n",
+			wantCommands: []string{},
+			wantManaged:  []string{"0079001", "0079002"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			managed := map[string]struct{}{}
+			secs, _, err := parseCLIStream("tmp/cli/techsupport_1.txt", strings.NewReader(tc.input), managed)
+			if err != nil {
+				t.Fatalf("parseCLIStream: %v", err)
+			}
+			got := []string{}
+			for _, s := range secs {
+				got = append(got, s.Command)
+				if s.SourcePath != "tmp/cli/techsupport_1.txt" {
+					t.Errorf("section %q source_path = %q", s.Command, s.SourcePath)
+				}
+				if want, ok := tc.wantBodies[s.Command]; ok && s.Body != want {
+					t.Errorf("section %q body = %q, want %q", s.Command, s.Body, want)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.wantCommands) {
+				t.Errorf("commands = %q, want %q", got, tc.wantCommands)
+			}
+			for _, serial := range tc.wantManaged {
+				if _, ok := managed[serial]; !ok {
+					t.Errorf("managed serial %s not collected (got %v)", serial, managed)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCLIStreamCaps(t *testing.T) {
+	t.Run("long line is cut and parsing continues", func(t *testing.T) {
+		input := "fw1> show system info\n" +
+			"hostname: " + strings.Repeat("x", maxCLILineBytes+10) + "\n" +
+			"serial: 0011\n"
+		secs, warnings, err := parseCLIStream("cli.txt", strings.NewReader(input), map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(secs) != 1 || !strings.HasSuffix(secs[0].Body, "serial: 0011\n") {
+			t.Fatalf("sections = %d, want the line after the long one kept", len(secs))
+		}
+		if first := strings.SplitN(secs[0].Body, "\n", 2)[0]; len(first) != maxCLILineBytes {
+			t.Errorf("long line kept %d bytes, want %d", len(first), maxCLILineBytes)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], "1 line(s) truncated") {
+			t.Errorf("warnings = %q", warnings)
+		}
+	})
+	t.Run("section is cut at the section cap", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("streams more than maxCLISectionBytes")
+		}
+		line := strings.Repeat(" ", maxCLILineBytes-2) + "y\n"
+		var b strings.Builder
+		b.WriteString("fw1> show system info\n")
+		for b.Len() < maxCLISectionBytes+2*len(line) {
+			b.WriteString(line)
+		}
+		b.WriteString("fw1> request license info\nFeature: WildFire\n")
+		secs, warnings, err := parseCLIStream("cli.txt", strings.NewReader(b.String()), map[string]struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(secs) != 2 || secs[1].Body != "Feature: WildFire\n" {
+			t.Fatalf("got %d sections, want the section after the capped one kept", len(secs))
+		}
+		if n := len(secs[0].Body); n < maxCLISectionBytes || n > maxCLISectionBytes+len(line) {
+			t.Errorf("capped section kept %d bytes, want about %d", n, maxCLISectionBytes)
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], `section "show system info" truncated`) {
+			t.Errorf("warnings = %q", warnings)
+		}
+	})
+}
+
+func TestCLISectionsFind(t *testing.T) {
+	secs := cliSections{
+		{Command: "show routing route type static", Body: "static"},
+		{Command: "show routing route", Body: "all"},
+	}
+	tests := []struct {
+		command string
+		want    string
+		found   bool
+	}{
+		{command: "show routing route", want: "all", found: true},
+		{command: "Show  Routing Route", want: "all", found: true},
+		{command: "show routing route type", want: "static", found: true},
+		{command: "show routing", want: "static", found: true},
+		{command: "show routing route type connect", found: false},
+	}
+	for _, tc := range tests {
+		sec, ok := secs.find(tc.command)
+		if ok != tc.found || sec.Body != tc.want {
+			t.Errorf("find(%q) = %q, %v; want %q, %v", tc.command, sec.Body, ok, tc.want, tc.found)
+		}
+	}
+}
+
+func TestExtractCLIIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		want        map[string]string
+		wantSection map[string]string
+	}{
+		{
+			name: "system info",
+			input: "fw1> show system info\n" +
+				"hostname: fw1\nip-address: 192.0.2.10\nmodel: PA-3220\nserial: 0011\nsw-version: 10.2.4\n" +
+				"cloud-mode: non-cloud\nsystem-mode: normal\n",
+			want: map[string]string{
+				"hostname": "fw1", "mgmt_ip": "192.0.2.10", "model": "PA-3220", "serial": "0011",
+				"panos_version": "10.2.4", "cloud_mode": "non-cloud", "system_mode": "normal",
+			},
+			wantSection: map[string]string{"model": "show system info"},
+		},
+		{
+			name: "HA falls back to the local block only",
+			input: "fw1> show high-availability all\n" +
+				"Local Information:\n  Model: PA-3220\n  Serial: 0011\n  Build Release: 10.2.4\n" +
+				"Peer Information:\n  Model: PA-5220\n  Serial: 0022\n" +
+				"fw1> show interface management\n  Ip address: 192.0.2.10\n",
+			want: map[string]string{
+				"hostname": "not_found", "mgmt_ip": "192.0.2.10", "model": "PA-3220", "serial": "0011",
+				"panos_version": "10.2.4", "cloud_mode": "not_found", "system_mode": "not_found",
+			},
+			wantSection: map[string]string{"model": "show high-availability all", "mgmt_ip": "show interface management"},
+		},
+		{
+			name: "peer-only HA output yields nothing",
+			input: "fw1> show high-availability all\n" +
+				"Peer Information:\n  Model: PA-5220\n  Serial: 0022\n",
+			want: map[string]string{
+				"hostname": "not_found", "mgmt_ip": "not_found", "model": "not_found", "serial": "not_found",
+				"panos_version": "not_found", "cloud_mode": "not_found", "system_mode": "not_found",
+			},
+			wantSection: map[string]string{"serial": "not_found"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			values, sources := extractCLIIdentity(parseCLIFixture(t, tc.input))
+			if !reflect.DeepEqual(values, tc.want) {
+				t.Errorf("identity = %v, want %v", values, tc.want)
+			}
+			for field, section := range tc.wantSection {
+				src, _ := sources[field].(map[string]any)
+				if src["section"] != section {
+					t.Errorf("%s section = %v, want %s", field, src["section"], section)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractLicenses(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []map[string]any
+	}{
+		{name: "no section", input: "fw1> show system info\nhostname: fw1\n", want: []map[string]any{}},
+		{
+			name: "blocks sorted by feature then expiry",
+			input: "fw1> request license info\n\nCurrent TLS state: ok\n\n" +
+				"License entry:\nFeature: WildFire License\nDescription: WildFire signature feed\nIssued: November 08, 2025\nExpires: November 08, 2026\nExpired?: no\n" +
+				"License entry:\nFeature: Threat Prevention\nDescription: Threat Prevention\nIssued: January 01, 2024\nExpires: January 01, 2025\nExpired?: yes\n" +
+				"License entry:\nFeature: PA-3220 Support\nExpires: Never\n" +
+				"License entry:\nDescription: entry without a feature\n",
+			want: []map[string]any{
+				{"feature": "PA-3220 Support", "description": "not_found", "issued": "not_found", "expires": "Never", "status": "unknown", "source_path": "tmp/cli/techsupport_1.txt"},
+				{"feature": "Threat Prevention", "description": "Threat Prevention", "issued": "January 01, 2024", "expires": "January 01, 2025", "status": "expired", "source_path": "tmp/cli/techsupport_1.txt"},
+				{"feature": "WildFire License", "description": "WildFire signature feed", "issued": "November 08, 2025", "expires": "November 08, 2026", "status": "active", "source_path": "tmp/cli/techsupport_1.txt"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractLicenses(parseCLIFixture(t, tc.input))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("extractLicenses =\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtractCLIHA(t *testing.T) {
+	base := func(over map[string]any) map[string]any {
+		out := map[string]any{
+			"enabled": "unknown", "mode": "not_found", "peer": "not_found",
+			"local_state": "not_found", "peer_state": "not_found", "peer_serial": "not_found",
+			"peer_mgmt_ip": "not_found", "source_path": "not_found",
+		}
+		for k, v := range over {
+			out[k] = v
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]any
+	}{
+		{name: "no section", input: "fw1> show system info\nhostname: fw1\n", want: base(nil)},
+		{
+			name:  "HA not enabled",
+			input: "fw1> show high-availability all\nHA not enabled\n",
+			want:  base(map[string]any{"enabled": "disabled", "source_path": "tmp/cli/techsupport_1.txt"}),
+		},
+		{
+			name: "local and peer blocks",
+			input: "fw1> show high-availability all\n" +
+				"Group 1:\n  Mode: Active-Passive\n  Local Information:\n    State: active (last 3 days)\n    Serial: 0011\n" +
+				"  Peer Information:\n    Mode: Active-Passive\n    State: passive (last 3 days)\n    Serial: 0022\n    Management IPv4 Address: 192.0.2.11/24\n",
+			want: base(map[string]any{
+				"enabled": "enabled", "mode": "active-passive", "local_state": "active",
+				"peer_state": "passive", "peer_serial": "0022", "peer_mgmt_ip": "192.0.2.11",
+				"source_path": "tmp/cli/techsupport_1.txt",
+			}),
+		},
+		{
+			name: "peer values never fill local ones",
+			input: "fw1> show high-availability all\n" +
+				"  Peer Information:\n    Mode: Active-Active\n    State: active-primary\n",
+			want: base(map[string]any{"peer_state": "active-primary", "source_path": "tmp/cli/techsupport_1.txt"}),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractCLIHA(parseCLIFixture(t, tc.input))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("extractCLIHA =\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigHA(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want map[string]string
+	}{
+		{name: "not configured", xml: `<config><devices><entry name="localhost.localdomain"/></devices></config>`, want: map[string]string{}},
+		{
+			name: "HA1 peer from config",
+			xml: `<config><devices><entry name="localhost.localdomain"><deviceconfig><high-availability>
+				<enabled>yes</enabled>
+				<group><mode><active-passive/></mode><peer-ip>10.255.0.2</peer-ip><peer-ip-backup>10.255.1.2</peer-ip-backup></group>
+			</high-availability></deviceconfig></entry></devices></config>`,
+			want: map[string]string{"enabled": "enabled", "mode": "active-passive", "peer": "10.255.0.2", "peer_backup": "10.255.1.2"},
+		},
+		{
+			name: "disabled",
+			xml:  `<config><devices><entry name="localhost.localdomain"><deviceconfig><high-availability><enabled>no</enabled></high-availability></deviceconfig></entry></devices></config>`,
+			want: map[string]string{"enabled": "disabled"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseConfigXML(strings.NewReader(tc.xml))
+			if err != nil {
+				t.Fatalf("parseConfigXML: %v", err)
+			}
+			if got := configHA(localDevice(cfg)); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("configHA = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtractRuntimeRoutes(t *testing.T) {
+	row := func(dest, nexthop, metric, flags, age, iface string) string {
+		return fmt.Sprintf("%-20s%-16s%-7s%-7s%-6s%-14s\n", dest, nexthop, metric, flags, age, iface)
+	}
+	header := row("destination", "nexthop", "metric", "flags", "age", "interface")
+	// view flattens a route to vr|destination|nexthop|interface|metric|flags|protocol.
+	view := func(routes []map[string]any) []string {
+		out := []string{}
+		for _, r := range routes {
+			out = append(out, fmt.Sprintf("%v|%v|%v|%v|%v|%v|%v", r["vr"], r["destination"], r["nexthop"], r["interface"], r["metric"], r["flags"], r["protocol"]))
+		}
+		return out
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "no section", input: "fw1> show system info\nhostname: fw1\n", want: []string{}},
+		{
+			name: "columns sliced by header offsets",
+			input: "fw1> show routing route\n" +
+				"VIRTUAL ROUTER: default (id 1)\n  ==========\n" + header +
+				row("0.0.0.0/0", "203.0.113.1", "10", "A S", "", "ethernet1/1") +
+				row("10.0.0.0/24", "0.0.0.0", "0", "A C", "", "ethernet1/2") +
+				row("10.9.0.0/16", "10.0.0.254", "", "A O2", "3600", "ethernet1/2"),
+			want: []string{
+				"default|0.0.0.0/0|203.0.113.1|ethernet1/1|10|A S|static",
+				"default|10.0.0.0/24|0.0.0.0|ethernet1/2|0|A C|connected",
+				"default|10.9.0.0/16|10.0.0.254|ethernet1/2|not_found|A O2|ospf",
+			},
+		},
+		{
+			name: "header-less rows fall back to tokens",
+			input: "fw1> show routing route\n" +
+				"10.1.0.0/16 10.0.0.1 10 A S ethernet1/3\n" +
+				"10.2.0.1/16 10.0.0.1 20 A O2 120 ethernet1/4\n" +
+				"192.0.2.0/24 10.0.0.9 ?B 5\n",
+			want: []string{
+				"not_found|10.1.0.0/16|10.0.0.1|ethernet1/3|10|A S|static",
+				"not_found|10.2.0.0/16|10.0.0.1|ethernet1/4|20|A O2|ospf",
+				"not_found|192.0.2.0/24|10.0.0.9|not_found|?B|not_found|unknown",
+			},
+		},
+		{
+			name: "row shifted against its header falls back to tokens",
+			input: "fw1> show routing route\nvirtual router: vr1\n" + header +
+				fmt.Sprintf("%-20s%-16s%-7s%-5s%s\n", "10.3.0.0/16", "10.0.0.1", "10", "A S", "ethernet1/5"),
+			want: []string{"vr1|10.3.0.0/16|10.0.0.1|ethernet1/5|10|A S|static"},
+		},
+		{
+			name: "advanced routing and several VRs",
+			input: "fw1> show advanced-routing route\n" +
+				"Logical Router: lr-b\n10.5.0.0/16 10.0.0.1 10 A B ethernet1/7\n" +
+				"Logical Router: lr-a\n10.4.0.0/16 10.0.0.1 10 A S ethernet1/6\n",
+			want: []string{
+				"lr-a|10.4.0.0/16|10.0.0.1|ethernet1/6|10|A S|static",
+				"lr-b|10.5.0.0/16|10.0.0.1|ethernet1/7|10|A B|bgp",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := view(extractRuntimeRoutes(parseCLIFixture(t, tc.input)))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("routes =\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// envCloneRef records the environment and commit a clone was forked from.
+type envCloneRef struct {
+	EnvID    string `json:"env_id"`
+	CommitID string `json:"commit_id"`
+}
+
+type cloneEnvRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// handleCloneEnvironment forks an environment: state, commits, snapshots and
+// the ingest log are copied into a new environment whose meta.json records
+// cloned_from, so what-if ingests never touch the source history.
+func (a *app) handleCloneEnvironment(w http.ResponseWriter, r *http.Request, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid request body")
+		return
+	}
+	var req cloneEnvRequest
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid JSON body")
+			return
+		}
+	}
+	if !a.claimEnvQueue(envID) {
+		writeError(w, http.StatusConflict, "ERR_ENV_BUSY", "environment has ingests in progress")
+		return
+	}
+	files, err := collectBundleFiles(envDir)
+	a.releaseEnvQueue(envID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environment files")
+		return
+	}
+	var source envMeta
+	if err := json.Unmarshal(files["meta.json"], &source); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environment metadata")
+		return
+	}
+
+	ref := &envCloneRef{EnvID: envID, CommitID: lastCommitID(files["commits.ndjson"])}
+	now := time.Now().UTC().Format(time.RFC3339)
+	meta := envMeta{
+		EnvID:       newUUID(),
+		Description: source.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Labels:      source.Labels,
+		ClonedFrom:  ref,
+	}
+	if req.Description != nil {
+		meta.Description = *req.Description
+	}
+
+	a.metaMu.Lock()
+	defer a.metaMu.Unlock()
+	if req.Name != nil {
+		name, nameErr := a.checkEnvName(*req.Name, "")
+		if nameErr != nil {
+			writeError(w, nameErr.Status, nameErr.Code, nameErr.Msg)
+			return
+		}
+		meta.Name = name
+	} else {
+		name, err := a.defaultCloneName(source.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environments")
+			return
+		}
+		meta.Name = name
+	}
+	rehome := map[string]any{
+		"source_summary": "clone of environment " + envID + " at commit " + ref.CommitID,
+		"cloned_from":    ref,
+	}
+	if err := a.installEnvironment(meta, files, rehome); err != nil {
+		writePersistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+// defaultCloneName picks the first free name of the form "<base> (clone)",
+// "<base> (clone 2)", ..., cutting base so the result fits
+// maxEnvNameLength. Callers hold metaMu.
+func (a *app) defaultCloneName(base string) (string, error) {
+	base = strings.TrimSpace(base)
+	for n := 1; ; n++ {
+		suffix := " (clone)"
+		if n > 1 {
+			suffix = fmt.Sprintf(" (clone %d)", n)
+		}
+		name := base
+		if room := maxEnvNameLength - utf8.RuneCountInString(suffix); utf8.RuneCountInString(name) > room {
+			name = strings.TrimSpace(string([]rune(name)[:room]))
+		}
+		name += suffix
+		taken, err := a.envNameTaken(name, "")
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+}
+
+// lastCommitID returns the commit_id of the final record in a commits.ndjson
+// payload, or "not_found" when the log is empty.
+func lastCommitID(log []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		var c map[string]any
+		if json.Unmarshal([]byte(lines[i]), &c) == nil {
+			if id := valueString(c["commit_id"], ""); id != "" {
+				return id
+			}
+		}
+	}
+	return "not_found"
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/netip"
+	"path"
+	"sort"
+	"strings"
+)
+
+type xmlNode struct {
+	Name     string
+	Attrs    map[string]string
+	Text     string
+	Children []*xmlNode
+}
+
+// configSkipElements are subtrees that can be very large in real configs and
+// carry nothing the extractor needs; the decoder streams past them.
+var configSkipElements = map[string]bool{
+	"rulebase":           true,
+	"pre-rulebase":       true,
+	"post-rulebase":      true,
+	"profiles":           true,
+	"profile-group":      true,
+	"application":        true,
+	"application-group":  true,
+	"application-filter": true,
+	"predefined":         true,
+	"threats":            true,
+	"reports":            true,
+	"log-settings":       true,
+}
+
+var configIfaceModes = []string{"layer3", "layer2", "virtual-wire", "tap", "ha", "decrypt-mirror", "log-card"}
+
+var configZoneTypes = []string{"layer3", "layer2", "virtual-wire", "tap", "tunnel", "external"}
+
+func parseConfigXML(r io.Reader) (*xmlNode, error) {
+	dec := xml.NewDecoder(r)
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	skip := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 || configSkipElements[t.Name.Local] {
+				skip++
+				continue
+			}
+			n := &xmlNode{Name: t.Name.Local, Attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.Attrs[a.Name.Local] = a.Value
+			}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if skip > 0 || len(stack) == 1 {
+				continue
+			}
+			stack[len(stack)-1].Text += string(t)
+		}
+	}
+	return root.child("config"), nil
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) path(names ...string) *xmlNode {
+	cur := n
+	for _, name := range names {
+		cur = cur.child(name)
+	}
+	return cur
+}
+
+func (n *xmlNode) entries() []*xmlNode {
+	if n == nil {
+		return nil
+	}
+	out := make([]*xmlNode, 0, len(n.Children))
+	for _, c := range n.Children {
+		if c.Name == "entry" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (n *xmlNode) entry(name string) *xmlNode {
+	for _, e := range n.entries() {
+		if e.attr("name") == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) members() []string {
+	if n == nil {
+		return []string{}
+	}
+	out := make([]string, 0, len(n.Children))
+	for _, c := range n.Children {
+		if c.Name == "member" && c.text() != "" {
+			out = append(out, c.text())
+		}
+	}
+	return out
+}
+
+func (n *xmlNode) text() string {
+	if n == nil {
+		return ""
+	}
+	return strings.TrimSpace(n.Text)
+}
+
+func (n *xmlNode) attr(name string) string {
+	if n == nil {
+		return ""
+	}
+	return n.Attrs[name]
+}
+
+func (n *xmlNode) firstChildOf(names []string) *xmlNode {
+	for _, name := range names {
+		if c := n.child(name); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// localDevice resolves /config/devices/entry[@name='localhost.localdomain'],
+// falling back to the first device entry.
+func localDevice(cfg *xmlNode) *xmlNode {
+	devices := cfg.child("devices")
+	if d := devices.entry("localhost.localdomain"); d != nil {
+		return d
+	}
+	if all := devices.entries(); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+// isPanoramaConfig reports whether a saved config is a Panorama's: managed
+// devices under mgt-config or device-groups under the local device entry.
+func isPanoramaConfig(cfg *xmlNode) bool {
+	if len(cfg.path("mgt-config", "devices").entries()) > 0 {
+		return true
+	}
+	return localDevice(cfg).child("device-group") != nil
+}
+
+func findConfigMember(names []string, patterns ...string) (string, bool) {
+	for _, p := range patterns {
+		for _, name := range names {
+			if matchMemberPattern(p, name) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchMemberPattern matches a "**/dir/file" style pattern against a tar
+// member path; "**/" matches any (possibly empty) leading directory prefix.
+func matchMemberPattern(pattern, name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	suffix := strings.TrimPrefix(pattern, "**/")
+	if suffix == pattern {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}
+	depth := strings.Count(suffix, "/") + 1
+	parts := strings.Split(name, "/")
+	if len(parts) < depth {
+		return false
+	}
+	ok, _ := path.Match(suffix, strings.Join(parts[len(parts)-depth:], "/"))
+	return ok
+}
+
+func extractConfigInventory(cfg *xmlNode, sourcePath, provenance string) map[string]any {
+	dev := localDevice(cfg)
+	addrs := configAddressObjects(cfg, dev)
+	inv := map[string]any{
+		"source_path":     sourcePath,
+		"hostname":        dev.path("deviceconfig", "system", "hostname").text(),
+		"mgmt_ip":         dev.path("deviceconfig", "system", "ip-address").text(),
+		"interfaces":      configInterfaces(dev, addrs, sourcePath),
+		"zones":           configZones(dev, sourcePath),
+		"virtual_routers": configVirtualRouters(dev, sourcePath),
+		"routes_config":   configStaticRoutes(dev, addrs, sourcePath),
+		"ha":              configHA(dev),
+	}
+	for _, k := range configNetworkKeys {
+		for _, rec := range inv[k].([]map[string]any) {
+			rec["provenance"] = provenance
+		}
+	}
+	return inv
+}
+
+// configHA reads deviceconfig/high-availability; an absent node yields an
+// empty map so callers can tell "not configured here" from "disabled".
+func configHA(dev *xmlNode) map[string]string {
+	ha := dev.path("deviceconfig", "high-availability")
+	if ha == nil {
+		return map[string]string{}
+	}
+	out := map[string]string{}
+	switch strings.ToLower(ha.child("enabled").text()) {
+	case "yes":
+		out["enabled"] = "enabled"
+	case "no", "":
+		out["enabled"] = "disabled"
+	}
+	group := ha.child("group")
+	if m := group.child("mode"); m != nil && len(m.Children) > 0 {
+		out["mode"] = m.Children[0].Name
+	}
+	if v := group.child("peer-ip").text(); v != "" {
+		out["peer"] = v
+	}
+	if v := group.child("peer-ip-backup").text(); v != "" {
+		out["peer_backup"] = v
+	}
+	return out
+}
+
+func configAddressObjects(cfg, dev *xmlNode) map[string]string {
+	out := map[string]string{}
+	collect := func(n *xmlNode) {
+		for _, e := range n.child("address").entries() {
+			if v := e.child("ip-netmask").text(); v != "" {
+				out[e.attr("name")] = v
+			}
+		}
+	}
+	collect(cfg.child("shared"))
+	for _, vsys := range dev.child("vsys").entries() {
+		collect(vsys)
+	}
+	return out
+}
+
+// resolveCIDR normalizes an interface/route address that may be a CIDR, a
+// bare host address, or the name of an address object.
+func resolveCIDR(v string, addrs map[string]string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if obj, ok := addrs[v]; ok {
+		v = obj
+	}
+	if pfx, err := netip.ParsePrefix(v); err == nil {
+		return pfx.String(), true
+	}
+	if ip, err := netip.ParseAddr(v); err == nil {
+		return netip.PrefixFrom(ip, ip.BitLen()).String(), true
+	}
+	return "", false
+}
+
+func configIPs(n *xmlNode, addrs map[string]string) []string {
+	out := make([]string, 0)
+	for _, e := range n.child("ip").entries() {
+		if cidr, ok := resolveCIDR(e.attr("name"), addrs); ok {
+			out = append(out, cidr)
+		}
+	}
+	sort.Strings(out)
+	return uniqueStrings(out)
+}
+
+func configZones(dev *xmlNode, sourcePath string) []map[string]any {
+	zones := make([]map[string]any, 0)
+	for _, vsys := range dev.child("vsys").entries() {
+		for _, z := range vsys.child("zone").entries() {
+			zt := z.child("network").firstChildOf(configZoneTypes)
+			zoneType := "not_found"
+			if zt != nil {
+				zoneType = zt.Name
+			}
+			members := zt.members()
+			sort.Strings(members)
+			zones = append(zones, map[string]any{
+				"name":        z.attr("name"),
+				"type":        zoneType,
+				"vsys":        vsys.attr("name"),
+				"members":     members,
+				"source_path": sourcePath,
+			})
+		}
+	}
+	sortZones(zones)
+	return zones
+}
+
+// sortZones orders zones by name, then vsys, so same-named zones from
+// different vsys have a stable order.
+func sortZones(zones []map[string]any) {
+	sort.Slice(zones, func(i, j int) bool {
+		ni, nj := valueString(zones[i]["name"], ""), valueString(zones[j]["name"], "")
+		if ni != nj {
+			return ni < nj
+		}
+		return valueString(zones[i]["vsys"], "") < valueString(zones[j]["vsys"], "")
+	})
+}
+
+// configVirtualRouters covers both legacy virtual-router entries and
+// advanced-routing logical-router VRFs.
+func configVirtualRouters(dev *xmlNode, sourcePath string) []map[string]any {
+	vrs := make([]map[string]any, 0)
+	add := func(name string, n *xmlNode) {
+		members := n.child("interface").members()
+		sort.Strings(members)
+		vrs = append(vrs, map[string]any{
+			"name":        name,
+			"interfaces":  members,
+			"source_path": sourcePath,
+		})
+	}
+	network := dev.child("network")
+	for _, vr := range network.child("virtual-router").entries() {
+		add(vr.attr("name"), vr)
+	}
+	for _, lr := range network.child("logical-router").entries() {
+		for _, vrf := range lr.child("vrf").entries() {
+			add(vrf.attr("name"), vrf)
+		}
+	}
+	sort.Slice(vrs, func(i, j int) bool {
+		return valueString(vrs[i]["name"], "") < valueString(vrs[j]["name"], "")
+	})
+	return vrs
+}
+
+func configInterfaces(dev *xmlNode, addrs map[string]string, sourcePath string) []map[string]any {
+	ifaceRoot := dev.path("network", "interface")
+	out := make([]map[string]any, 0)
+	unit := func(name string, n *xmlNode) map[string]any {
+		return map[string]any{
+			"name":     name,
+			"ip_cidrs": configIPs(n, addrs),
+			"tag":      valueString(n.child("tag").text(), "not_found"),
+			"zone":     "not_found",
+			"vr":       "not_found",
+		}
+	}
+	iface := func(name, ifType, mode string, units []map[string]any) map[string]any {
+		sort.Slice(units, func(i, j int) bool {
+			return valueString(units[i]["name"], "") < valueString(units[j]["name"], "")
+		})
+		return map[string]any{
+			"name":         name,
+			"type":         ifType,
+			"mode":         mode,
+			"zone":         "not_found",
+			"vr":           "not_found",
+			"layer3_units": units,
+			"source_path":  sourcePath,
+		}
+	}
+
+	for _, ifType := range []string{"ethernet", "aggregate-ethernet"} {
+		for _, e := range ifaceRoot.child(ifType).entries() {
+			name := e.attr("name")
+			mode := "not_found"
+			units := make([]map[string]any, 0)
+			if m := e.firstChildOf(configIfaceModes); m != nil {
+				mode = m.Name
+			}
+			if ag := e.child("aggregate-group").text(); ag != "" {
+				mode = "aggregate-group"
+			}
+			if l3 := e.child("layer3"); l3 != nil {
+				units = append(units, unit(name, l3))
+				for _, u := range l3.child("units").entries() {
+					units = append(units, unit(u.attr("name"), u))
+				}
+			}
+			rec := iface(name, ifType, mode, units)
+			rec["aggregate_group"] = valueString(e.child("aggregate-group").text(), "not_found")
+			out = append(out, rec)
+		}
+	}
+
+	for _, ifType := range []string{"loopback", "vlan", "tunnel"} {
+		n := ifaceRoot.child(ifType)
+		if n == nil {
+			continue
+		}
+		units := make([]map[string]any, 0)
+		if n.child("ip") != nil {
+			units = append(units, unit(ifType, n))
+		}
+		for _, u := range n.child("units").entries() {
+			units = append(units, unit(u.attr("name"), u))
+		}
+		rec := iface(ifType, ifType, "layer3", units)
+		rec["aggregate_group"] = "not_found"
+		out = append(out, rec)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return valueString(out[i]["name"], "") < valueString(out[j]["name"], "")
+	})
+	return out
+}
+
+func configStaticRoutes(dev *xmlNode, addrs map[string]string, sourcePath string) []map[string]any {
+	out := make([]map[string]any, 0)
+	add := func(vr string, rt *xmlNode) {
+		for _, sr := range rt.path("ip", "static-route").entries() {
+			dst, ok := resolveCIDR(sr.child("destination").text(), addrs)
+			if !ok {
+				continue
+			}
+			nh := sr.child("nexthop")
+			nexthop := "not_found"
+			switch {
+			case nh.child("ip-address").text() != "":
+				nexthop = nh.child("ip-address").text()
+			case nh.child("ipv4-address").text() != "":
+				nexthop = nh.child("ipv4-address").text()
+			case nh.child("next-vr").text() != "":
+				nexthop = "next-vr:" + nh.child("next-vr").text()
+			case nh.child("fqdn").text() != "":
+				nexthop = nh.child("fqdn").text()
+			case nh.child("discard") != nil:
+				nexthop = "discard"
+			}
+			out = append(out, map[string]any{
+				"vr":          vr,
+				"destination": dst,
+				"nexthop":     nexthop,
+				"interface":   valueString(sr.child("interface").text(), "not_found"),
+				"metric":      valueString(sr.child("metric").text(), "not_found"),
+				"reason":      "static",
+				"source_type": "config",
+				"source_path": sourcePath,
+			})
+		}
+	}
+	network := dev.child("network")
+	for _, vr := range network.child("virtual-router").entries() {
+		add(vr.attr("name"), vr.child("routing-table"))
+	}
+	for _, lr := range network.child("logical-router").entries() {
+		for _, vrf := range lr.child("vrf").entries() {
+			add(vrf.attr("name"), vrf.child("routing-table"))
+		}
+	}
+	sortRoutes(out)
+	return out
+}
+
+func sortRoutes(routes []map[string]any) {
+	key := func(r map[string]any) []string {
+		return []string{
+			valueString(r["vr"], ""),
+			valueString(r["destination"], ""),
+			valueString(r["nexthop"], ""),
+			valueString(r["interface"], ""),
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		ki, kj := key(routes[i]), key(routes[j])
+		for k := range ki {
+			if ki[k] != kj[k] {
+				return ki[k] < kj[k]
+			}
+		}
+		return false
+	})
+}
+
+var configNetworkKeys = []string{"interfaces", "zones", "virtual_routers", "routes_config"}
+
+// configLayers lists config sources from lowest to highest priority; the
+// Panorama-pushed config wins over the local saved config for network objects.
+var configLayers = []struct {
+	provenance string
+	patterns   []string
+}{
+	{provenance: "local_config", patterns: []string{"**/saved-configs/running-config.xml", "**/saved-configs/techsupport-saved-currcfg.xml"}},
+	{provenance: "panorama_pushed", patterns: []string{"**/panorama_pushed/mergesp.xml", "**/panorama_pushed/*push*.xml"}},
+}
+
+// applyConfigExtraction layers saved and Panorama-pushed config onto the
+// CLI-derived fields: network inventory comes from config, identity only
+// fills gaps left by runtime sections.
+func applyConfigExtraction(scan *tsfScan, extracted map[string]any) error {
+	for _, k := range configNetworkKeys {
+		extracted[k] = []map[string]any{}
+	}
+	names := make([]string, 0, len(scan.Configs)+len(scan.ConfigErrs))
+	for name := range scan.Configs {
+		names = append(names, name)
+	}
+	for name := range scan.ConfigErrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	layers := make([]map[string]any, 0, len(configLayers))
+	for _, layer := range configLayers {
+		member, ok := findConfigMember(names, layer.patterns...)
+		if !ok {
+			continue
+		}
+		if err := scan.ConfigErrs[member]; err != nil {
+			return err
+		}
+		layers = append(layers, extractConfigInventory(scan.Configs[member], member, layer.provenance))
+	}
+	defer annotateRouteZones(extracted)
+	if len(layers) == 0 {
+		return nil
+	}
+
+	merged := mergeConfigInventories(layers)
+	annotateMembership(merged)
+	for _, k := range configNetworkKeys {
+		extracted[k] = merged[k]
+	}
+
+	ha, _ := extracted["ha"].(map[string]any)
+	if ha == nil {
+		ha = map[string]any{}
+		extracted["ha"] = ha
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		cfgHA := layers[i]["ha"].(map[string]string)
+		if len(cfgHA) == 0 {
+			continue
+		}
+		for k, v := range cfgHA {
+			ha[k] = v
+		}
+		ha["config_source_path"] = layers[i]["source_path"]
+		break
+	}
+
+	sources, _ := extracted["field_sources"].(map[string]any)
+	if sources == nil {
+		sources = map[string]any{}
+		extracted["field_sources"] = sources
+	}
+	fallbacks := map[string]string{
+		"hostname": "/config/devices/entry[@name='localhost.localdomain']/deviceconfig/system/hostname",
+		"mgmt_ip":  "/config/devices/entry[@name='localhost.localdomain']/deviceconfig/system/ip-address",
+	}
+	for field, xpath := range fallbacks {
+		if valueString(extracted[field], "not_found") != "not_found" {
+			continue
+		}
+		for i := len(layers) - 1; i >= 0; i-- {
+			v := valueString(layers[i][field], "")
+			if v == "" {
+				continue
+			}
+			extracted[field] = v
+			sources[field] = map[string]any{"section": xpath, "source_path": layers[i]["source_path"]}
+			break
+		}
+	}
+	return nil
+}
+
+// mergeConfigInventories overlays each layer onto the previous ones; records
+// with the same key are replaced wholesale by the higher-priority layer.
+func mergeConfigInventories(layers []map[string]any) map[string]any {
+	routeKey := func(r map[string]any) string {
+		return strings.Join([]string{
+			valueString(r["vr"], ""),
+			valueString(r["destination"], ""),
+			valueString(r["nexthop"], ""),
+			valueString(r["interface"], ""),
+		}, "|")
+	}
+	nameKey := func(r map[string]any) string { return valueString(r["name"], "") }
+	// Zone names are only unique within a vsys.
+	zoneKey := func(r map[string]any) string { return valueString(r["vsys"], "") + "|" + nameKey(r) }
+	keys := map[string]func(map[string]any) string{
+		"interfaces":      nameKey,
+		"zones":           zoneKey,
+		"virtual_routers": nameKey,
+		"routes_config":   routeKey,
+	}
+
+	merged := map[string]any{}
+	for _, k := range configNetworkKeys {
+		byKey := map[string]map[string]any{}
+		for _, layer := range layers {
+			for _, rec := range layer[k].([]map[string]any) {
+				byKey[keys[k](rec)] = rec
+			}
+		}
+		out := make([]map[string]any, 0, len(byKey))
+		for _, rec := range byKey {
+			out = append(out, rec)
+		}
+		switch k {
+		case "routes_config":
+			sortRoutes(out)
+		case "zones":
+			sortZones(out)
+		default:
+			sort.Slice(out, func(i, j int) bool { return nameKey(out[i]) < nameKey(out[j]) })
+		}
+		merged[k] = out
+	}
+	return merged
+}
+
+// annotateMembership sets zone and virtual-router membership on interfaces
+// and their layer3 units from the merged zone and VR tables.
+func annotateMembership(merged map[string]any) {
+	zoneOf := map[string]string{}
+	for _, z := range merged["zones"].([]map[string]any) {
+		for _, m := range z["members"].([]string) {
+			zoneOf[m] = valueString(z["name"], "")
+		}
+	}
+	vrOf := map[string]string{}
+	for _, vr := range merged["virtual_routers"].([]map[string]any) {
+		for _, m := range vr["interfaces"].([]string) {
+			vrOf[m] = valueString(vr["name"], "")
+		}
+	}
+	for _, iface := range merged["interfaces"].([]map[string]any) {
+		name := valueString(iface["name"], "")
+		iface["zone"] = valueString(zoneOf[name], "not_found")
+		iface["vr"] = valueString(vrOf[name], "not_found")
+		for _, u := range iface["layer3_units"].([]map[string]any) {
+			unit := valueString(u["name"], "")
+			u["zone"] = valueString(zoneOf[unit], "not_found")
+			u["vr"] = valueString(vrOf[unit], "not_found")
+		}
+	}
+}
+
+// annotateRouteZones maps each config and runtime route's egress interface to
+// its zone using the extracted interface -> zone table.
+func annotateRouteZones(extracted map[string]any) {
+	zoneOf := map[string]string{}
+	for _, it := range toAnySlice(extracted["interfaces"]) {
+		iface, _ := it.(map[string]any)
+		zoneOf[valueString(iface["name"], "")] = valueString(iface["zone"], "not_found")
+		for _, u := range toAnySlice(iface["layer3_units"]) {
+			unit, _ := u.(map[string]any)
+			zoneOf[valueString(unit["name"], "")] = valueString(unit["zone"], "not_found")
+		}
+	}
+	for _, k := range []string{"routes_config", "routes_runtime"} {
+		for _, it := range toAnySlice(extracted[k]) {
+			r, _ := it.(map[string]any)
+			if r == nil {
+				continue
+			}
+			r["zone"] = valueString(zoneOf[valueString(r["interface"], "")], "not_found")
+		}
+	}
+}
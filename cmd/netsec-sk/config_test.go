@@ -0,0 +1,308 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustParseConfig(t *testing.T, doc string) *xmlNode {
+	t.Helper()
+	cfg, err := parseConfigXML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("parseConfigXML: %v", err)
+	}
+	return cfg
+}
+
+// localConfigXML is a trimmed running-config with two vsys, an address
+// object, a legacy virtual router and an advanced-routing VRF.
+const localConfigXML = `<?xml version="1.0"?>
+<config version="10.2.0">
+  <shared><address><entry name="bh-net"><ip-netmask>192.0.2.128/25</ip-netmask></entry></address></shared>
+  <devices><entry name="localhost.localdomain">
+    <deviceconfig><system><hostname>fw1</hostname><ip-address>192.0.2.10</ip-address></system></deviceconfig>
+    <network>
+      <interface>
+        <ethernet>
+          <entry name="ethernet1/1"><layer3><ip><entry name="203.0.113.2/24"/></ip></layer3></entry>
+          <entry name="ethernet1/2"><layer3>
+            <ip><entry name="10.0.0.1/24"/></ip>
+            <units><entry name="ethernet1/2.10"><tag>10</tag><ip><entry name="10.10.0.1/24"/></ip></entry></units>
+          </layer3></entry>
+          <entry name="ethernet1/3"><aggregate-group>ae1</aggregate-group></entry>
+        </ethernet>
+        <loopback><units><entry name="loopback.1"><ip><entry name="10.255.255.1"/></ip></entry></units></loopback>
+      </interface>
+      <virtual-router><entry name="default">
+        <interface><member>ethernet1/2</member><member>ethernet1/1</member></interface>
+        <routing-table><ip><static-route>
+          <entry name="default"><destination>0.0.0.0/0</destination><nexthop><ip-address>203.0.113.1</ip-address></nexthop><interface>ethernet1/1</interface><metric>10</metric></entry>
+          <entry name="blackhole"><destination>bh-net</destination><nexthop><discard/></nexthop></entry>
+        </static-route></ip></routing-table>
+      </entry></virtual-router>
+      <logical-router><entry name="lr"><vrf><entry name="vrf-b"><interface><member>ethernet1/2.10</member></interface></entry></vrf></entry></logical-router>
+    </network>
+    <vsys>
+      <entry name="vsys1">
+        <zone>
+          <entry name="untrust"><network><layer3><member>ethernet1/1</member></layer3></network></entry>
+          <entry name="trust"><network><layer3><member>ethernet1/2</member></layer3></network></entry>
+        </zone>
+        <rulebase><security><rules><entry name="allow"><to><member>untrust</member></to></entry></rules></security></rulebase>
+      </entry>
+      <entry name="vsys2">
+        <zone><entry name="trust"><network><layer3><member>ethernet1/2.10</member></layer3></network></entry></zone>
+      </entry>
+    </vsys>
+  </entry></devices>
+</config>`
+
+// pushedConfigXML is a Panorama push that redefines vsys1/trust and adds a
+// static route.
+const pushedConfigXML = `<config>
+  <devices><entry name="localhost.localdomain">
+    <deviceconfig><high-availability><enabled>yes</enabled><group><mode><active-passive/></mode><peer-ip>10.255.0.2</peer-ip></group></high-availability></deviceconfig>
+    <network><virtual-router><entry name="default">
+      <interface><member>ethernet1/1</member><member>ethernet1/2</member></interface>
+      <routing-table><ip><static-route>
+      <entry name="corp"><destination>172.16.0.0/12</destination><nexthop><ip-address>10.0.0.254</ip-address></nexthop><interface>ethernet1/2</interface></entry>
+    </static-route></ip></routing-table></entry></virtual-router></network>
+    <vsys><entry name="vsys1"><zone>
+      <entry name="trust"><network><layer3><member>ethernet1/2</member><member>loopback.1</member></layer3></network></entry>
+    </zone></entry></vsys>
+  </entry></devices>
+</config>`
+
+func TestExtractConfigInventory(t *testing.T) {
+	inv := extractConfigInventory(mustParseConfig(t, localConfigXML), "saved-configs/running-config.xml", "local_config")
+	if inv["hostname"] != "fw1" || inv["mgmt_ip"] != "192.0.2.10" {
+		t.Errorf("hostname, mgmt_ip = %v, %v", inv["hostname"], inv["mgmt_ip"])
+	}
+
+	type ifaceView struct{ name, typ, mode, agg string }
+	gotIfaces := []ifaceView{}
+	units := map[string][]string{}
+	for _, rec := range inv["interfaces"].([]map[string]any) {
+		gotIfaces = append(gotIfaces, ifaceView{rec["name"].(string), rec["type"].(string), rec["mode"].(string), rec["aggregate_group"].(string)})
+		for _, u := range rec["layer3_units"].([]map[string]any) {
+			units[u["name"].(string)] = u["ip_cidrs"].([]string)
+		}
+		if rec["provenance"] != "local_config" {
+			t.Errorf("interface %v provenance = %v", rec["name"], rec["provenance"])
+		}
+	}
+	wantIfaces := []ifaceView{
+		{"ethernet1/1", "ethernet", "layer3", "not_found"},
+		{"ethernet1/2", "ethernet", "layer3", "not_found"},
+		{"ethernet1/3", "ethernet", "aggregate-group", "ae1"},
+		{"loopback", "loopback", "layer3", "not_found"},
+	}
+	if !reflect.DeepEqual(gotIfaces, wantIfaces) {
+		t.Errorf("interfaces = %v, want %v", gotIfaces, wantIfaces)
+	}
+	wantUnits := map[string][]string{
+		"ethernet1/1":    {"203.0.113.2/24"},
+		"ethernet1/2":    {"10.0.0.1/24"},
+		"ethernet1/2.10": {"10.10.0.1/24"},
+		"loopback.1":     {"10.255.255.1/32"},
+	}
+	if !reflect.DeepEqual(units, wantUnits) {
+		t.Errorf("layer3 units = %v, want %v", units, wantUnits)
+	}
+
+	gotZones := []string{}
+	for _, z := range inv["zones"].([]map[string]any) {
+		gotZones = append(gotZones, z["vsys"].(string)+"/"+z["name"].(string)+"="+strings.Join(z["members"].([]string), ","))
+	}
+	wantZones := []string{"vsys1/trust=ethernet1/2", "vsys2/trust=ethernet1/2.10", "vsys1/untrust=ethernet1/1"}
+	if !reflect.DeepEqual(gotZones, wantZones) {
+		t.Errorf("zones = %q, want %q", gotZones, wantZones)
+	}
+
+	gotVRs := []string{}
+	for _, vr := range inv["virtual_routers"].([]map[string]any) {
+		gotVRs = append(gotVRs, vr["name"].(string)+"="+strings.Join(vr["interfaces"].([]string), ","))
+	}
+	wantVRs := []string{"default=ethernet1/1,ethernet1/2", "vrf-b=ethernet1/2.10"}
+	if !reflect.DeepEqual(gotVRs, wantVRs) {
+		t.Errorf("virtual routers = %q, want %q", gotVRs, wantVRs)
+	}
+
+	gotRoutes := []string{}
+	for _, r := range inv["routes_config"].([]map[string]any) {
+		gotRoutes = append(gotRoutes, strings.Join([]string{r["vr"].(string), r["destination"].(string), r["nexthop"].(string), r["interface"].(string), r["metric"].(string)}, "|"))
+	}
+	wantRoutes := []string{
+		"default|0.0.0.0/0|203.0.113.1|ethernet1/1|10",
+		"default|192.0.2.128/25|discard|not_found|not_found",
+	}
+	if !reflect.DeepEqual(gotRoutes, wantRoutes) {
+		t.Errorf("static routes = %q, want %q", gotRoutes, wantRoutes)
+	}
+}
+
+func TestParseConfigXMLSkipsLargeSubtrees(t *testing.T) {
+	cfg := mustParseConfig(t, localConfigXML)
+	vsys1 := localDevice(cfg).child("vsys").entry("vsys1")
+	if vsys1 == nil || vsys1.child("zone") == nil {
+		t.Fatal("vsys1 zones not parsed")
+	}
+	if vsys1.child("rulebase") != nil {
+		t.Error("rulebase was kept")
+	}
+	if _, err := parseConfigXML(strings.NewReader("<config><devices>")); err == nil {
+		t.Error("truncated XML parsed without error")
+	}
+}
+
+func TestMatchMemberPattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/saved-configs/running-config.xml", "opt/pancfg/mgmt/saved-configs/running-config.xml", true},
+		{"**/saved-configs/running-config.xml", "saved-configs/running-config.xml", true},
+		{"**/saved-configs/running-config.xml", "./saved-configs/running-config.xml", true},
+		{"**/saved-configs/running-config.xml", "saved-configs/old/running-config.xml", false},
+		{"**/panorama_pushed/*push*.xml", "x/panorama_pushed/template-push.xml", true},
+		{"**/panorama_pushed/*push*.xml", "panorama_pushed/mergesp.xml", false},
+	}
+	for _, tc := range tests {
+		if got := matchMemberPattern(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("matchMemberPattern(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestApplyConfigExtraction(t *testing.T) {
+	const localPath = "opt/pancfg/mgmt/saved-configs/running-config.xml"
+	const pushedPath = "opt/pancfg/mgmt/panorama_pushed/mergesp.xml"
+	scan := &tsfScan{
+		Configs: map[string]*xmlNode{
+			localPath:  mustParseConfig(t, localConfigXML),
+			pushedPath: mustParseConfig(t, pushedConfigXML),
+		},
+		ConfigErrs: map[string]error{},
+	}
+	extracted := map[string]any{
+		"hostname": "not_found",
+		"mgmt_ip":  "192.0.2.99",
+		"ha":       map[string]any{"enabled": "unknown", "local_state": "active"},
+		"routes_runtime": []any{
+			map[string]any{"destination": "10.10.0.0/24", "interface": "ethernet1/2.10"},
+		},
+	}
+	if err := applyConfigExtraction(scan, extracted); err != nil {
+		t.Fatalf("applyConfigExtraction: %v", err)
+	}
+
+	gotZones := []string{}
+	for _, z := range extracted["zones"].([]map[string]any) {
+		gotZones = append(gotZones, z["vsys"].(string)+"/"+z["name"].(string)+"="+strings.Join(z["members"].([]string), ",")+" "+z["provenance"].(string))
+	}
+	wantZones := []string{
+		"vsys1/trust=ethernet1/2,loopback.1 panorama_pushed",
+		"vsys2/trust=ethernet1/2.10 local_config",
+		"vsys1/untrust=ethernet1/1 local_config",
+	}
+	if !reflect.DeepEqual(gotZones, wantZones) {
+		t.Errorf("zones = %q, want %q", gotZones, wantZones)
+	}
+
+	zoneOf := map[string]string{}
+	for _, iface := range extracted["interfaces"].([]map[string]any) {
+		for _, u := range iface["layer3_units"].([]map[string]any) {
+			zoneOf[u["name"].(string)] = u["zone"].(string) + "@" + u["vr"].(string)
+		}
+	}
+	wantZoneOf := map[string]string{
+		"ethernet1/1":    "untrust@default",
+		"ethernet1/2":    "trust@default",
+		"ethernet1/2.10": "trust@vrf-b",
+		"loopback.1":     "trust@not_found",
+	}
+	if !reflect.DeepEqual(zoneOf, wantZoneOf) {
+		t.Errorf("unit zone@vr = %v, want %v", zoneOf, wantZoneOf)
+	}
+
+	for _, vr := range extracted["virtual_routers"].([]map[string]any) {
+		want := map[string]string{"default": "panorama_pushed", "vrf-b": "local_config"}[vr["name"].(string)]
+		if vr["provenance"] != want {
+			t.Errorf("virtual router %v provenance = %v, want %s", vr["name"], vr["provenance"], want)
+		}
+	}
+
+	gotRoutes := []string{}
+	for _, r := range extracted["routes_config"].([]map[string]any) {
+		gotRoutes = append(gotRoutes, r["destination"].(string)+" "+r["provenance"].(string)+" "+r["zone"].(string))
+	}
+	wantRoutes := []string{
+		"0.0.0.0/0 local_config untrust",
+		"172.16.0.0/12 panorama_pushed trust",
+		"192.0.2.128/25 local_config not_found",
+	}
+	if !reflect.DeepEqual(gotRoutes, wantRoutes) {
+		t.Errorf("config routes = %q, want %q", gotRoutes, wantRoutes)
+	}
+	if rt := extracted["routes_runtime"].([]any)[0].(map[string]any); rt["zone"] != "trust" {
+		t.Errorf("runtime route zone = %v, want trust", rt["zone"])
+	}
+
+	wantHA := map[string]any{
+		"enabled": "enabled", "mode": "active-passive", "peer": "10.255.0.2",
+		"local_state": "active", "config_source_path": pushedPath,
+	}
+	if !reflect.DeepEqual(extracted["ha"], wantHA) {
+		t.Errorf("ha = %v, want %v", extracted["ha"], wantHA)
+	}
+
+	if extracted["hostname"] != "fw1" || extracted["mgmt_ip"] != "192.0.2.99" {
+		t.Errorf("hostname, mgmt_ip = %v, %v; want config fallback only for the missing hostname", extracted["hostname"], extracted["mgmt_ip"])
+	}
+	src, _ := extracted["field_sources"].(map[string]any)["hostname"].(map[string]any)
+	if src["source_path"] != localPath {
+		t.Errorf("hostname source = %v, want %s", src, localPath)
+	}
+}
+
+func TestApplyConfigExtractionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		scan    *tsfScan
+		wantErr bool
+		zones   int
+	}{
+		{
+			name:  "no config members",
+			scan:  &tsfScan{Configs: map[string]*xmlNode{}, ConfigErrs: map[string]error{}},
+			zones: 0,
+		},
+		{
+			name:  "local config only",
+			scan:  &tsfScan{Configs: map[string]*xmlNode{"saved-configs/running-config.xml": mustParseConfig(t, localConfigXML)}, ConfigErrs: map[string]error{}},
+			zones: 3,
+		},
+		{
+			name: "unparsable config layer",
+			scan: &tsfScan{
+				Configs:    map[string]*xmlNode{"saved-configs/running-config.xml": mustParseConfig(t, localConfigXML)},
+				ConfigErrs: map[string]error{"panorama_pushed/mergesp.xml": io.ErrUnexpectedEOF},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			extracted := map[string]any{}
+			err := applyConfigExtraction(tc.scan, extracted)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("applyConfigExtraction error = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && len(extracted["zones"].([]map[string]any)) != tc.zones {
+				t.Errorf("zones = %v, want %d", extracted["zones"], tc.zones)
+			}
+		})
+	}
+}
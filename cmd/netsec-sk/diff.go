@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type stateChange struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+type commitDetail struct {
+	CommitID        string        `json:"commit_id"`
+	EnvID           string        `json:"env_id"`
+	StateHashBefore string        `json:"state_hash_before"`
+	StateHashAfter  string        `json:"state_hash_after"`
+	Changes         []stateChange `json:"changes"`
+}
+
+// canonicalCopy round-trips v through JSON so it can be diffed generically
+// (maps, []any, float64) and is detached from later in-place mutation.
+func canonicalCopy(v any) (any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// diffJSON returns the structural differences between two canonical JSON
+// documents as JSON Pointer paths. A subtree that only exists on one side is
+// reported once at its root. Arrays whose elements all carry a unique
+// identity (logical_device_id, route key, name, ...) are matched by identity
+// so an insertion does not show up as a change to every later index; other
+// arrays are compared by position.
+func diffJSON(before, after any) []stateChange {
+	out := make([]stateChange, 0)
+	diffValue("", before, after, &out)
+	return out
+}
+
+func diffValue(ptr string, before, after any, out *[]stateChange) {
+	switch b := before.(type) {
+	case map[string]any:
+		a, ok := after.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(b)+len(a))
+		for k := range b {
+			keys = append(keys, k)
+		}
+		for k := range a {
+			if _, ok := b[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := ptr + "/" + escapePointer(k)
+			bv, inB := b[k]
+			av, inA := a[k]
+			switch {
+			case !inA:
+				*out = append(*out, stateChange{Op: "remove", Path: p, Before: bv})
+			case !inB:
+				*out = append(*out, stateChange{Op: "add", Path: p, After: av})
+			default:
+				diffValue(p, bv, av, out)
+			}
+		}
+		return
+	case []any:
+		a, ok := after.([]any)
+		if !ok {
+			break
+		}
+		if bKeys, aKeys := arrayIdentities(b), arrayIdentities(a); bKeys != nil && aKeys != nil {
+			aIndex := make(map[string]int, len(aKeys))
+			for i, k := range aKeys {
+				aIndex[k] = i
+			}
+			bIndex := make(map[string]int, len(bKeys))
+			for i, k := range bKeys {
+				bIndex[k] = i
+				if j, ok := aIndex[k]; ok {
+					diffValue(fmt.Sprintf("%s/%d", ptr, j), b[i], a[j], out)
+				} else {
+					*out = append(*out, stateChange{Op: "remove", Path: fmt.Sprintf("%s/%d", ptr, i), Before: b[i]})
+				}
+			}
+			for j, k := range aKeys {
+				if _, ok := bIndex[k]; !ok {
+					*out = append(*out, stateChange{Op: "add", Path: fmt.Sprintf("%s/%d", ptr, j), After: a[j]})
+				}
+			}
+			return
+		}
+		n := len(b)
+		if len(a) > n {
+			n = len(a)
+		}
+		for i := 0; i < n; i++ {
+			p := fmt.Sprintf("%s/%d", ptr, i)
+			switch {
+			case i >= len(a):
+				*out = append(*out, stateChange{Op: "remove", Path: p, Before: b[i]})
+			case i >= len(b):
+				*out = append(*out, stateChange{Op: "add", Path: p, After: a[i]})
+			default:
+				diffValue(p, b[i], a[i], out)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(before, after) {
+		*out = append(*out, stateChange{Op: "replace", Path: ptr, Before: before, After: after})
+	}
+}
+
+// arrayIdentities returns one identity per element, or nil when the array
+// cannot be matched by identity (scalars, missing or duplicate keys).
+func arrayIdentities(arr []any) []string {
+	keys := make([]string, 0, len(arr))
+	seen := map[string]bool{}
+	for _, it := range arr {
+		m, ok := it.(map[string]any)
+		if !ok {
+			return nil
+		}
+		k := elementIdentity(m)
+		if k == "" || seen[k] {
+			return nil
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func elementIdentity(m map[string]any) string {
+	if id := valueString(m["logical_device_id"], ""); id != "" {
+		return "id:" + id
+	}
+	if dst := valueString(m["destination"], ""); dst != "" {
+		return "route:" + strings.Join([]string{valueString(m["vr"], ""), dst, valueString(m["nexthop"], ""), valueString(m["interface"], "")}, "|")
+	}
+	if a, b := valueString(m["fw_a_logical_device_id"], ""), valueString(m["fw_b_logical_device_id"], ""); a != "" && b != "" {
+		return "edge:" + a + "|" + b
+	}
+	if ids := toAnySlice(m["logical_device_ids"]); len(ids) > 0 {
+		parts := make([]string, 0, len(ids))
+		for _, id := range ids {
+			parts = append(parts, valueString(id, ""))
+		}
+		return "group:" + strings.Join(parts, "|")
+	}
+	if f := valueString(m["feature"], ""); f != "" {
+		return "license:" + f + "|" + valueString(m["expires"], "")
+	}
+	if s := valueString(m["serial"], ""); s != "" && m["first_seen_ingest_id"] != nil {
+		return "serial:" + s
+	}
+	if n := valueString(m["name"], ""); n != "" {
+		return "name:" + n
+	}
+	return ""
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// summarizeStateChange renders change_summary bullets such as
+// "fw-dc1-01: PAN-OS 10.2.4 → 11.1.2, +3 routes" from the device-level
+// differences between two canonical states.
+func summarizeStateChange(before, after any) []string {
+	bDevs := devicesByID(before)
+	aDevs := devicesByID(after)
+	ids := make([]string, 0, len(bDevs)+len(aDevs))
+	for id := range bDevs {
+		ids = append(ids, id)
+	}
+	for id := range aDevs {
+		if _, ok := bDevs[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		hi, hj := deviceLabel(aDevs[ids[i]], bDevs[ids[i]], ids[i]), deviceLabel(aDevs[ids[j]], bDevs[ids[j]], ids[j])
+		if hi != hj {
+			return hi < hj
+		}
+		return ids[i] < ids[j]
+	})
+
+	out := make([]string, 0)
+	for _, id := range ids {
+		b, a := bDevs[id], aDevs[id]
+		label := deviceLabel(a, b, id)
+		switch {
+		case b == nil:
+			idn := mapAt(a, "current", "identity")
+			out = append(out, fmt.Sprintf("%s: added %s (%s, PAN-OS %s)", label, valueString(a["device_type"], "device"), valueString(idn["model"], "not_found"), valueString(idn["panos_version"], "not_found")))
+			continue
+		case a == nil:
+			out = append(out, fmt.Sprintf("%s: removed", label))
+			continue
+		}
+		parts := make([]string, 0)
+		bIdn, aIdn := mapAt(b, "current", "identity"), mapAt(a, "current", "identity")
+		for _, f := range []struct{ key, label string }{
+			{"hostname", "hostname"},
+			{"serial", "serial"},
+			{"model", "model"},
+			{"panos_version", "PAN-OS"},
+			{"mgmt_ip", "mgmt IP"},
+		} {
+			bv, av := valueString(bIdn[f.key], "not_found"), valueString(aIdn[f.key], "not_found")
+			if bv != av {
+				parts = append(parts, fmt.Sprintf("%s %s → %s", f.label, bv, av))
+			}
+		}
+		bHA, aHA := mapAt(b, "current", "ha"), mapAt(a, "current", "ha")
+		if bv, av := valueString(bHA["local_state"], "not_found"), valueString(aHA["local_state"], "not_found"); bv != av {
+			parts = append(parts, fmt.Sprintf("HA %s → %s", bv, av))
+		}
+		bNet, aNet := mapAt(b, "current", "network"), mapAt(a, "current", "network")
+		routesB := append(toAnySlice(bNet["routes_runtime"]), toAnySlice(bNet["routes_config"])...)
+		routesA := append(toAnySlice(aNet["routes_runtime"]), toAnySlice(aNet["routes_config"])...)
+		parts = append(parts, countDelta(routesB, routesA, "routes")...)
+		parts = append(parts, countDelta(toAnySlice(bNet["interfaces"]), toAnySlice(aNet["interfaces"]), "interfaces")...)
+		parts = append(parts, countDelta(toAnySlice(bNet["zones"]), toAnySlice(aNet["zones"]), "zones")...)
+		parts = append(parts, countDelta(toAnySlice(mapAt(b, "current")["licenses"]), toAnySlice(mapAt(a, "current")["licenses"]), "licenses")...)
+		if len(parts) == 0 {
+			if n := len(diffJSON(b, a)); n > 0 {
+				parts = append(parts, fmt.Sprintf("%d fields updated", n))
+			}
+		}
+		if len(parts) > 0 {
+			out = append(out, label+": "+strings.Join(parts, ", "))
+		}
+	}
+
+	bTopo, aTopo := mapAt(asMap(before), "topology"), mapAt(asMap(after), "topology")
+	topo := countDelta(toAnySlice(bTopo["inferred_adjacencies"]), toAnySlice(aTopo["inferred_adjacencies"]), "adjacencies")
+	topo = append(topo, countDelta(toAnySlice(bTopo["ha_groups"]), toAnySlice(aTopo["ha_groups"]), "HA pairs")...)
+	if len(topo) > 0 {
+		out = append(out, "topology: "+strings.Join(topo, ", "))
+	}
+	if len(out) == 0 {
+		out = append(out, "state metadata updated")
+	}
+	return out
+}
+
+// countDelta reports how many identities were added and removed between two
+// arrays, e.g. ["+3 routes", "-1 routes"]. Changed-in-place records are not
+// counted.
+func countDelta(before, after []any, noun string) []string {
+	bKeys, aKeys := map[string]bool{}, map[string]bool{}
+	for _, it := range before {
+		raw, _ := json.Marshal(identityOrValue(it))
+		bKeys[string(raw)] = true
+	}
+	for _, it := range after {
+		raw, _ := json.Marshal(identityOrValue(it))
+		aKeys[string(raw)] = true
+	}
+	added, removed := 0, 0
+	for k := range aKeys {
+		if !bKeys[k] {
+			added++
+		}
+	}
+	for k := range bKeys {
+		if !aKeys[k] {
+			removed++
+		}
+	}
+	out := make([]string, 0, 2)
+	if added > 0 {
+		out = append(out, fmt.Sprintf("+%d %s", added, noun))
+	}
+	if removed > 0 {
+		out = append(out, fmt.Sprintf("-%d %s", removed, noun))
+	}
+	return out
+}
+
+func identityOrValue(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		if k := elementIdentity(m); k != "" {
+			return k
+		}
+	}
+	return v
+}
+
+func devicesByID(state any) map[string]map[string]any {
+	out := map[string]map[string]any{}
+	for _, it := range toAnySlice(mapAt(asMap(state), "devices")["logical"]) {
+		dev, _ := it.(map[string]any)
+		if id := valueString(dev["logical_device_id"], ""); id != "" {
+			out[id] = dev
+		}
+	}
+	return out
+}
+
+func deviceLabel(after, before map[string]any, id string) string {
+	for _, dev := range []map[string]any{after, before} {
+		if h := valueString(mapAt(dev, "current", "identity")["hostname"], "not_found"); dev != nil && h != "not_found" {
+			return h
+		}
+	}
+	return id
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func mapAt(m map[string]any, keys ...string) map[string]any {
+	cur := m
+	for _, k := range keys {
+		cur, _ = cur[k].(map[string]any)
+	}
+	return cur
+}
+
+// changePaths lists the JSON Pointers touched by a diff, in diff order.
+func changePaths(changes []stateChange) []string {
+	out := make([]string, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, c.Path)
+	}
+	return out
+}
+
+func writeCommitDetail(envDir string, detail commitDetail) error {
+	path := filepath.Join(envDir, "commits", detail.CommitID+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(detail, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// recordStateCommit appends commit to commits.ndjson with change_paths (and,
+// unless the caller set one, change_summary) derived from the canonical
+// before/after documents, and stores the after-state snapshot and the diff
+// sidecar. commit must carry commit_id, env_id and both state hashes.
+func recordStateCommit(envDir string, commit map[string]any, beforeDoc, afterDoc any) error {
+	changes := diffJSON(beforeDoc, afterDoc)
+	commit["change_paths"] = changePaths(changes)
+	if _, ok := commit["change_summary"]; !ok {
+		commit["change_summary"] = summarizeStateChange(beforeDoc, afterDoc)
+	}
+	if _, err := writeStateSnapshot(envDir, afterDoc); err != nil {
+		return err
+	}
+	_ = writeCommitDetail(envDir, commitDetail{
+		CommitID:        stringValue(commit["commit_id"]),
+		EnvID:           stringValue(commit["env_id"]),
+		StateHashBefore: stringValue(commit["state_hash_before"]),
+		StateHashAfter:  stringValue(commit["state_hash_after"]),
+		Changes:         changes,
+	})
+	return writeNDJSONLine(filepath.Join(envDir, "commits.ndjson"), commit)
+}
+
+func (a *app) handleGetCommit(w http.ResponseWriter, envID, commitID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	commits, err := readCommits(filepath.Join(envDir, "commits.ndjson"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read commits")
+		return
+	}
+	var commit map[string]any
+	for _, c := range commits {
+		if stringValue(c["commit_id"]) == commitID {
+			commit = c
+			break
+		}
+	}
+	if commit == nil {
+		writeError(w, http.StatusNotFound, "ERR_COMMIT_NOT_FOUND", "commit not found")
+		return
+	}
+
+	// Commits written before diffs were recorded have no sidecar; they are
+	// returned with an empty change list rather than an error.
+	commit["changes"] = []stateChange{}
+	if b, err := os.ReadFile(filepath.Join(envDir, "commits", commitID+".json")); err == nil {
+		var detail map[string]any
+		if json.Unmarshal(b, &detail) == nil {
+			commit["changes"] = detail["changes"]
+		}
+	}
+	writeJSON(w, http.StatusOK, commit)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []string
+	}{
+		{
+			name:   "identical",
+			before: `{"a":1,"b":[1,2]}`,
+			after:  `{"a":1,"b":[1,2]}`,
+			want:   []string{},
+		},
+		{
+			name:   "scalar replace",
+			before: `{"a":1}`,
+			after:  `{"a":2}`,
+			want:   []string{"replace /a"},
+		},
+		{
+			name:   "key added and removed",
+			before: `{"a":1,"b":2}`,
+			after:  `{"b":2,"c":3}`,
+			want:   []string{"remove /a", "add /c"},
+		},
+		{
+			name:   "subtree reported once at its root",
+			before: `{}`,
+			after:  `{"net":{"routes":[1,2,3]}}`,
+			want:   []string{"add /net"},
+		},
+		{
+			name:   "type change is a replace",
+			before: `{"a":{"x":1}}`,
+			after:  `{"a":"x"}`,
+			want:   []string{"replace /a"},
+		},
+		{
+			name:   "pointer escaping",
+			before: `{"a/b":1,"c~d":1}`,
+			after:  `{"a/b":2,"c~d":2}`,
+			want:   []string{"replace /a~1b", "replace /c~0d"},
+		},
+		{
+			name:   "reordered device list is not a change",
+			before: `{"logical":[{"logical_device_id":"d1","v":1},{"logical_device_id":"d2","v":2}]}`,
+			after:  `{"logical":[{"logical_device_id":"d2","v":2},{"logical_device_id":"d1","v":1}]}`,
+			want:   []string{},
+		},
+		{
+			name:   "reordered device list reports changes at the new index",
+			before: `{"logical":[{"logical_device_id":"d1","v":1},{"logical_device_id":"d2","v":2}]}`,
+			after:  `{"logical":[{"logical_device_id":"d2","v":2},{"logical_device_id":"d1","v":9}]}`,
+			want:   []string{"replace /logical/1/v"},
+		},
+		{
+			name:   "insertion does not shift later elements",
+			before: `{"routes":[{"vr":"default","destination":"10.0.0.0/8"},{"vr":"default","destination":"10.2.0.0/16"}]}`,
+			after:  `{"routes":[{"vr":"default","destination":"10.0.0.0/8"},{"vr":"default","destination":"10.1.0.0/16"},{"vr":"default","destination":"10.2.0.0/16"}]}`,
+			want:   []string{"add /routes/1"},
+		},
+		{
+			name:   "removal by identity",
+			before: `{"zones":[{"name":"trust"},{"name":"untrust"}]}`,
+			after:  `{"zones":[{"name":"untrust"}]}`,
+			want:   []string{"remove /zones/0"},
+		},
+		{
+			name:   "duplicate identities fall back to positions",
+			before: `{"zones":[{"name":"trust","v":1},{"name":"trust","v":2}]}`,
+			after:  `{"zones":[{"name":"trust","v":2},{"name":"trust","v":1}]}`,
+			want:   []string{"replace /zones/0/v", "replace /zones/1/v"},
+		},
+		{
+			name:   "scalar arrays compare by position",
+			before: `{"members":["a","b"]}`,
+			after:  `{"members":["b","a","c"]}`,
+			want:   []string{"replace /members/0", "replace /members/1", "add /members/2"},
+		},
+		{
+			name:   "serial history matched by serial",
+			before: `{"h":[{"serial":"S1","first_seen_ingest_id":"i1","last_seen_at":"t1"}]}`,
+			after:  `{"h":[{"serial":"S2","first_seen_ingest_id":"i2","last_seen_at":"t2"},{"serial":"S1","first_seen_ingest_id":"i1","last_seen_at":"t3"}]}`,
+			want:   []string{"replace /h/1/last_seen_at", "add /h/0"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var before, after any
+			if err := json.Unmarshal([]byte(tc.before), &before); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.after), &after); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, c := range diffJSON(before, after) {
+				got = append(got, c.Op+" "+c.Path)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("diffJSON = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffJSONCarriesValues(t *testing.T) {
+	before := map[string]any{"a": "x"}
+	after := map[string]any{"a": "y"}
+	got := diffJSON(before, after)
+	want := []stateChange{{Op: "replace", Path: "/a", Before: "x", After: "y"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffJSON = %+v, want %+v", got, want)
+	}
+}
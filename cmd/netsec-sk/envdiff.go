@@ -0,0 +1,324 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// haCompareFields are the HA settings expected to match between mirrored
+// environments; peer addresses are site-specific and are not compared.
+var haCompareFields = []string{"enabled", "mode", "local_state", "peer_state"}
+
+type envDiffSide struct {
+	EnvID string `json:"env_id"`
+	Name  string `json:"name"`
+}
+
+type envDiffDevice struct {
+	LogicalDeviceID string `json:"logical_device_id"`
+	Hostname        string `json:"hostname"`
+	Serial          string `json:"serial"`
+	Model           string `json:"model"`
+	PanosVersion    string `json:"panos_version"`
+}
+
+type envDiffMatch struct {
+	MatchedBy   string         `json:"matched_by"`
+	Left        envDiffDevice  `json:"left"`
+	Right       envDiffDevice  `json:"right"`
+	Identical   bool           `json:"identical"`
+	Differences map[string]any `json:"differences"`
+}
+
+type envDiffResponse struct {
+	Left      envDiffSide     `json:"left"`
+	Right     envDiffSide     `json:"right"`
+	Summary   map[string]int  `json:"summary"`
+	Matched   []envDiffMatch  `json:"matched"`
+	OnlyLeft  []envDiffDevice `json:"only_left"`
+	OnlyRight []envDiffDevice `json:"only_right"`
+}
+
+// handleEnvDiff serves GET /api/diff?left=<env>&right=<env>. Logical devices
+// are paired by serial first and then by hostname; each pair reports
+// differences in PAN-OS version, licenses, routes, zones and HA settings.
+func (a *app) handleEnvDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	leftID, rightID := r.URL.Query().Get("left"), r.URL.Query().Get("right")
+	if leftID == "" || rightID == "" {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "left and right environment IDs are required")
+		return
+	}
+
+	states := make([]*State, 0, 2)
+	sides := make([]envDiffSide, 0, 2)
+	for _, envID := range []string{leftID, rightID} {
+		envDir, status := a.resolveEnvironmentPath(envID)
+		if status != http.StatusOK {
+			if status == http.StatusGone {
+				writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted: "+envID)
+				return
+			}
+			writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found: "+envID)
+			return
+		}
+		state, err := a.readState(envDir)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to load state")
+			return
+		}
+		meta, _ := readMeta(filepath.Join(envDir, "meta.json"))
+		states = append(states, state)
+		sides = append(sides, envDiffSide{EnvID: envID, Name: meta.Name})
+	}
+
+	resp := envDiffResponse{
+		Left:      sides[0],
+		Right:     sides[1],
+		Summary:   map[string]int{},
+		Matched:   make([]envDiffMatch, 0),
+		OnlyLeft:  make([]envDiffDevice, 0),
+		OnlyRight: make([]envDiffDevice, 0),
+	}
+	left, right := states[0].Devices.Logical, states[1].Devices.Logical
+	pairs, unmatchedLeft, unmatchedRight := matchDevices(left, right)
+	for _, p := range pairs {
+		m := envDiffMatch{
+			MatchedBy:   p.by,
+			Left:        diffDeviceInfo(p.left),
+			Right:       diffDeviceInfo(p.right),
+			Differences: compareDevices(p.left, p.right),
+		}
+		m.Identical = len(m.Differences) == 0
+		if m.Identical {
+			resp.Summary["identical"]++
+		} else {
+			resp.Summary["different"]++
+		}
+		resp.Matched = append(resp.Matched, m)
+	}
+	for _, dev := range unmatchedLeft {
+		resp.OnlyLeft = append(resp.OnlyLeft, diffDeviceInfo(dev))
+	}
+	for _, dev := range unmatchedRight {
+		resp.OnlyRight = append(resp.OnlyRight, diffDeviceInfo(dev))
+	}
+	resp.Summary["matched"] = len(resp.Matched)
+	resp.Summary["only_left"] = len(resp.OnlyLeft)
+	resp.Summary["only_right"] = len(resp.OnlyRight)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type devicePair struct {
+	by          string
+	left, right *LogicalDevice
+}
+
+func matchDevices(left, right []*LogicalDevice) ([]devicePair, []*LogicalDevice, []*LogicalDevice) {
+	usedL := make([]bool, len(left))
+	usedR := make([]bool, len(right))
+	pairs := make([]devicePair, 0)
+	keyOf := map[string]func(Identity) string{
+		"serial":   func(idn Identity) string { return idn.Serial },
+		"hostname": func(idn Identity) string { return idn.Hostname },
+	}
+	for _, key := range []string{"serial", "hostname"} {
+		index := map[string]int{}
+		for j, dev := range right {
+			if usedR[j] {
+				continue
+			}
+			v := strings.ToLower(valueString(keyOf[key](dev.snapshot().Identity), "not_found"))
+			if _, dup := index[v]; v != "not_found" && !dup {
+				index[v] = j
+			}
+		}
+		for i, dev := range left {
+			if usedL[i] {
+				continue
+			}
+			v := strings.ToLower(valueString(keyOf[key](dev.snapshot().Identity), "not_found"))
+			j, ok := index[v]
+			if v == "not_found" || !ok || usedR[j] {
+				continue
+			}
+			usedL[i], usedR[j] = true, true
+			pairs = append(pairs, devicePair{by: key, left: dev, right: right[j]})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].left.snapshot().Identity.Hostname < pairs[j].left.snapshot().Identity.Hostname
+	})
+
+	var onlyL, onlyR []*LogicalDevice
+	for i, dev := range left {
+		if !usedL[i] {
+			onlyL = append(onlyL, dev)
+		}
+	}
+	for j, dev := range right {
+		if !usedR[j] {
+			onlyR = append(onlyR, dev)
+		}
+	}
+	return pairs, onlyL, onlyR
+}
+
+func diffDeviceInfo(dev *LogicalDevice) envDiffDevice {
+	idn := dev.snapshot().Identity
+	return envDiffDevice{
+		LogicalDeviceID: valueString(dev.LogicalDeviceID, "not_found"),
+		Hostname:        valueString(idn.Hostname, "not_found"),
+		Serial:          valueString(idn.Serial, "not_found"),
+		Model:           valueString(idn.Model, "not_found"),
+		PanosVersion:    valueString(idn.PanosVersion, "not_found"),
+	}
+}
+
+type licenseView struct {
+	Feature string `json:"feature"`
+	Expires string `json:"expires"`
+	Status  string `json:"status"`
+}
+
+type routeView struct {
+	VR          string `json:"vr"`
+	Destination string `json:"destination"`
+	Nexthop     string `json:"nexthop"`
+	Interface   string `json:"interface"`
+}
+
+type zoneView struct {
+	Vsys    string   `json:"vsys"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Members []string `json:"members"`
+}
+
+// compareDevices returns only the categories that differ, so an empty map
+// means the pair is identical for the compared fields.
+func compareDevices(left, right *LogicalDevice) map[string]any {
+	out := map[string]any{}
+	l, r := left.snapshot(), right.snapshot()
+
+	lv := valueString(l.Identity.PanosVersion, "not_found")
+	rv := valueString(r.Identity.PanosVersion, "not_found")
+	if lv != rv {
+		out["panos_version"] = map[string]any{"left": lv, "right": rv}
+	}
+
+	viewLicense := func(lic License) (string, licenseView) {
+		return lic.Feature, licenseView{
+			Feature: lic.Feature,
+			Expires: valueString(lic.Expires, "not_found"),
+			Status:  valueString(lic.Status, "unknown"),
+		}
+	}
+	if d := compareKeyed(l.Licenses, r.Licenses, viewLicense); d != nil {
+		out["licenses"] = d
+	}
+
+	viewRoute := func(rt Route) (string, routeView) {
+		v := routeView{
+			VR:          valueString(rt.VR, "not_found"),
+			Destination: valueString(rt.Destination, "not_found"),
+			Nexthop:     valueString(rt.Nexthop, "not_found"),
+			Interface:   valueString(rt.Interface, "not_found"),
+		}
+		return strings.Join([]string{v.VR, v.Destination, v.Nexthop, v.Interface}, "|"), v
+	}
+	if d := compareKeyed(l.Network.routes(), r.Network.routes(), viewRoute); d != nil {
+		out["routes"] = d
+	}
+
+	// Zone names are only unique within a vsys, as in the config merge.
+	viewZone := func(z Zone) (string, zoneView) {
+		if z.Name == "" {
+			return "", zoneView{}
+		}
+		members := append([]string{}, z.Members...)
+		sort.Strings(members)
+		v := zoneView{Vsys: valueString(z.Vsys, "not_found"), Name: z.Name, Type: valueString(z.Type, "unknown"), Members: members}
+		return z.Vsys + "|" + z.Name, v
+	}
+	if d := compareKeyed(l.Network.Zones, r.Network.Zones, viewZone); d != nil {
+		out["zones"] = d
+	}
+
+	haFields := func(ha HAInfo) map[string]string {
+		return map[string]string{
+			"enabled":     valueString(ha.Enabled, "unknown"),
+			"mode":        valueString(ha.Mode, "not_found"),
+			"local_state": valueString(ha.LocalState, "not_found"),
+			"peer_state":  valueString(ha.PeerState, "not_found"),
+		}
+	}
+	lHA, rHA := haFields(l.HA), haFields(r.HA)
+	ha := map[string]any{}
+	for _, f := range haCompareFields {
+		if lHA[f] != rHA[f] {
+			ha[f] = map[string]any{"left": lHA[f], "right": rHA[f]}
+		}
+	}
+	if len(ha) > 0 {
+		out["ha"] = ha
+	}
+	return out
+}
+
+type keyedChange[V any] struct {
+	Key   string `json:"key"`
+	Left  V      `json:"left"`
+	Right V      `json:"right"`
+}
+
+// compareKeyed matches two record lists by key and reports records present
+// on one side only and, when their views differ, records present on both.
+// It returns nil when the lists are equivalent.
+func compareKeyed[T, V any](left, right []T, view func(T) (string, V)) map[string]any {
+	index := func(arr []T) (map[string]V, []string) {
+		out := map[string]V{}
+		keys := make([]string, 0, len(arr))
+		for _, it := range arr {
+			k, v := view(it)
+			if k == "" {
+				continue
+			}
+			if _, ok := out[k]; !ok {
+				keys = append(keys, k)
+			}
+			out[k] = v
+		}
+		sort.Strings(keys)
+		return out, keys
+	}
+	lIdx, lKeys := index(left)
+	rIdx, rKeys := index(right)
+
+	onlyL, onlyR, changed := make([]V, 0), make([]V, 0), make([]keyedChange[V], 0)
+	for _, k := range lKeys {
+		rv, ok := rIdx[k]
+		if !ok {
+			onlyL = append(onlyL, lIdx[k])
+			continue
+		}
+		if !reflect.DeepEqual(lIdx[k], rv) {
+			changed = append(changed, keyedChange[V]{Key: k, Left: lIdx[k], Right: rv})
+		}
+	}
+	for _, k := range rKeys {
+		if _, ok := lIdx[k]; !ok {
+			onlyR = append(onlyR, rIdx[k])
+		}
+	}
+	if len(onlyL) == 0 && len(onlyR) == 0 && len(changed) == 0 {
+		return nil
+	}
+	return map[string]any{"only_left": onlyL, "only_right": onlyR, "changed": changed}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareDevicesZones(t *testing.T) {
+	device := func(zones ...Zone) *LogicalDevice {
+		return &LogicalDevice{Current: &Snapshot{Network: Network{Zones: zones}}}
+	}
+	trust1 := Zone{Vsys: "vsys1", Name: "trust", Type: "layer3", Members: []string{"ethernet1/2", "ethernet1/1"}}
+	trust2 := Zone{Vsys: "vsys2", Name: "trust", Type: "layer3", Members: []string{"ethernet1/3"}}
+
+	tests := []struct {
+		name  string
+		left  *LogicalDevice
+		right *LogicalDevice
+		want  any
+	}{
+		{
+			name:  "same zones in another order",
+			left:  device(trust1, trust2),
+			right: device(trust2, trust1),
+			want:  nil,
+		},
+		{
+			name:  "same name in another vsys is a different zone",
+			left:  device(trust1, trust2),
+			right: device(trust1),
+			want: map[string]any{
+				"only_left":  []zoneView{{Vsys: "vsys2", Name: "trust", Type: "layer3", Members: []string{"ethernet1/3"}}},
+				"only_right": []zoneView{},
+				"changed":    []keyedChange[zoneView]{},
+			},
+		},
+		{
+			name:  "members compared within the vsys",
+			left:  device(trust1, trust2),
+			right: device(trust1, Zone{Vsys: "vsys2", Name: "trust", Type: "layer3"}),
+			want: map[string]any{
+				"only_left":  []zoneView{},
+				"only_right": []zoneView{},
+				"changed": []keyedChange[zoneView]{{
+					Key:   "vsys2|trust",
+					Left:  zoneView{Vsys: "vsys2", Name: "trust", Type: "layer3", Members: []string{"ethernet1/3"}},
+					Right: zoneView{Vsys: "vsys2", Name: "trust", Type: "layer3", Members: []string{}},
+				}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := compareDevices(tc.left, tc.right)["zones"]
+			if tc.want == nil {
+				if got != nil {
+					t.Errorf("zones = %+v, want no difference", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("zones = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
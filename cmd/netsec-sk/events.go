@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type ingestEvent struct {
+	Name string
+	Data map[string]any
+}
+
+// ingestEventFor renders the SSE payload for the ingest's current status.
+func ingestEventFor(st *ingestStatus) ingestEvent {
+	data := map[string]any{
+		"ingest_id":  st.IngestID,
+		"status":     st.Status,
+		"stage":      st.Stage,
+		"pct":        st.Progress.Pct,
+		"message":    st.Progress.Message,
+		"elapsed_ms": maxInt64(time.Since(st.StartedAt).Milliseconds(), 0),
+	}
+	name := "stage"
+	switch st.Status {
+	case "queued":
+		name = "queued"
+		data["queue_position"] = st.QueuePosition
+	case "awaiting_user":
+		name = "awaiting_user"
+		data["rma_prompt"] = st.RMAPrompt
+	case "completed":
+		name = "completed"
+		data["final_record"] = st.FinalRecord
+		if total, ok := st.FinalRecord["duration_ms_total"].(int64); ok {
+			data["elapsed_ms"] = total
+		}
+	}
+	return ingestEvent{Name: name, Data: data}
+}
+
+// publishIngestEvent fans the current status out to every /events stream for
+// the ingest. Sends never block the worker: a subscriber that falls behind
+// misses intermediate stages, but completion closes its channel and the
+// handler then reports the final record from the ingest index.
+func (a *app) publishIngestEvent(st *ingestStatus) {
+	ev := ingestEventFor(st)
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	for ch := range a.ingestSubs[st.IngestID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+		if st.Status == "completed" {
+			close(ch)
+		}
+	}
+	if st.Status == "completed" {
+		delete(a.ingestSubs, st.IngestID)
+	}
+}
+
+func (a *app) subscribeIngest(ingestID string) chan ingestEvent {
+	ch := make(chan ingestEvent, 64)
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	if a.ingestSubs[ingestID] == nil {
+		a.ingestSubs[ingestID] = map[chan ingestEvent]bool{}
+	}
+	a.ingestSubs[ingestID][ch] = true
+	return ch
+}
+
+func (a *app) unsubscribeIngest(ingestID string, ch chan ingestEvent) {
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+	if subs := a.ingestSubs[ingestID]; subs[ch] {
+		delete(subs, ch)
+		if len(subs) == 0 {
+			delete(a.ingestSubs, ingestID)
+		}
+	}
+}
+
+// handleIngestEvents serves GET /api/ingests/{id}/events. The ingest_id is
+// returned as soon as the upload is spooled, before the archive is scanned,
+// so a subscriber sees every stage from queued through scan to completed.
+func (a *app) handleIngestEvents(w http.ResponseWriter, r *http.Request, ingestID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "ERR_STREAM_UNSUPPORTED", "streaming is not supported")
+		return
+	}
+	// Subscribe before reading the snapshot so no transition falls between.
+	ch := a.subscribeIngest(ingestID)
+	defer a.unsubscribeIngest(ingestID, ch)
+	st, ok := a.getIngest(ingestID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_INGEST_NOT_FOUND", "ingest not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev ingestEvent) {
+		raw, _ := json.Marshal(ev.Data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, raw)
+		flusher.Flush()
+	}
+	send(ingestEventFor(&st))
+	if st.Status == "completed" {
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case ev, open := <-ch:
+			if !open {
+				if final, ok := a.getIngest(ingestID); ok {
+					send(ingestEventFor(&final))
+				}
+				return
+			}
+			send(ev)
+			if ev.Name == "completed" {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishIngestEventClosesOnCompletion(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	st := newIngestStatus(envID, "tsf.tgz", "upload")
+	a.storeIngest(st)
+	live := a.subscribeIngest(st.IngestID)
+	// A subscriber that never reads must not block the worker, and is still
+	// closed on completion.
+	stalled := a.subscribeIngest(st.IngestID)
+	for i := 0; i < cap(stalled)+1; i++ {
+		a.publishIngestEvent(st)
+	}
+	for len(live) > 0 {
+		<-live
+	}
+
+	a.setStage(st, "scan", 15, "scanning archive")
+	if ev := <-live; ev.Name != "stage" || ev.Data["stage"] != "scan" {
+		t.Errorf("stage event = %s %v", ev.Name, ev.Data)
+	}
+	a.failScan(envDir, st, &scanError{Stage: "scan", Code: "ERR_INVALID_ARCHIVE", Msg: "archive is not a readable gzip/tar"})
+
+	ev, open := <-live
+	if !open || ev.Name != "completed" || ev.Data["final_record"] == nil {
+		t.Fatalf("completion event = %s %v (open %v), want completed with final_record", ev.Name, ev.Data, open)
+	}
+	if _, open := <-live; open {
+		t.Error("live subscriber still open after completion")
+	}
+	for range stalled {
+	}
+	a.eventsMu.Lock()
+	subs := a.ingestSubs[st.IngestID]
+	a.eventsMu.Unlock()
+	if subs != nil {
+		t.Errorf("subscribers after completion = %v, want none", subs)
+	}
+	// Unsubscribing after the channel was closed is a no-op.
+	a.unsubscribeIngest(st.IngestID, live)
+}
+
+func TestHandleIngestEvents(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	st := newIngestStatus(envID, "tsf.tgz", "upload")
+	a.storeIngest(st)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/ingests/"+st.IngestID+"/events", nil)
+	done := make(chan struct{})
+	go func() {
+		a.handleIngestEvents(rec, req, st.IngestID)
+		close(done)
+	}()
+	// Wait for the stream to subscribe so completion reaches it live.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		a.eventsMu.Lock()
+		n := len(a.ingestSubs[st.IngestID])
+		a.eventsMu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("events handler did not subscribe")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.failScan(envDir, st, &scanError{Stage: "receive", Code: "ERR_INVALID_ARCHIVE", Msg: "failed to receive upload"})
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("events stream did not end after completion")
+	}
+	body := rec.Body.String()
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "event: stage\n") || !strings.Contains(body, "event: completed\n") || !strings.Contains(body, "ERR_INVALID_ARCHIVE") {
+		t.Errorf("stream = %q, want a stage event then completed with the error record", body)
+	}
+
+	// A completed ingest gets its final event and the stream ends at once.
+	rec = httptest.NewRecorder()
+	a.handleIngestEvents(rec, req, st.IngestID)
+	if got := rec.Body.String(); strings.Count(got, "event: ") != 1 || !strings.HasPrefix(got, "event: completed\n") {
+		t.Errorf("stream for completed ingest = %q, want only the completed event", got)
+	}
+
+	rec = httptest.NewRecorder()
+	a.handleIngestEvents(rec, req, newUUID())
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown ingest status = %d, want 404", rec.Code)
+	}
+}
@@ -48,13 +48,13 @@ func (a *app) handleFlowTrace(w http.ResponseWriter, r *http.Request, envID stri
 		return
 	}
 
-	state, err := loadState(envDir)
+	state, err := a.readState(envDir)
 	if err != nil {
 		writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "environment state not found")
 		return
 	}
 
-	devs := logicalDevices(state)
+	devs := state.Devices.Logical
 	srcDev := resolveSourceFirewall(devs, src)
 	if srcDev == nil {
 		writeError(w, http.StatusNotFound, "ERR_FLOW_SRC_NOT_FOUND", "source firewall not found")
@@ -66,9 +66,7 @@ func (a *app) handleFlowTrace(w http.ResponseWriter, r *http.Request, envID stri
 		return
 	}
 
-	srcID := valueString(srcDev["logical_device_id"], "")
-	dstID := valueString(dstDev["logical_device_id"], "")
-	path := findPathByTopology(state, srcID, dstID)
+	path := findPathByTopology(state, srcDev.LogicalDeviceID, dstDev.LogicalDeviceID)
 	if len(path) == 0 {
 		writeError(w, http.StatusNotFound, "ERR_FLOW_PATH_NOT_FOUND", "no deterministic path found")
 		return
@@ -76,14 +74,12 @@ func (a *app) handleFlowTrace(w http.ResponseWriter, r *http.Request, envID stri
 
 	hops := make([]flowHop, 0, len(path))
 	for i, id := range path {
-		dev := findDeviceByID(devs, id)
-		cur, _ := dev["current"].(map[string]any)
-		identity, _ := cur["identity"].(map[string]any)
+		dev := state.deviceByID(id)
 		egressZone, usedDefault := resolveEgressZone(dev, dst)
 		hops = append(hops, flowHop{
 			Index:           i,
 			LogicalDeviceID: id,
-			Hostname:        valueString(identity["hostname"], "not_found"),
+			Hostname:        valueString(dev.snapshot().Identity.Hostname, "not_found"),
 			IngressZone:     "not_found",
 			EgressZone:      egressZone,
 			UsedDefault:     usedDefault,
@@ -99,10 +95,10 @@ func (a *app) handleFlowTrace(w http.ResponseWriter, r *http.Request, envID stri
 	})
 }
 
-func resolveSourceFirewall(devs []map[string]any, src netip.Addr) map[string]any {
-	connected := make([]map[string]any, 0)
+func resolveSourceFirewall(devs []*LogicalDevice, src netip.Addr) *LogicalDevice {
+	connected := make([]*LogicalDevice, 0)
 	for _, d := range devs {
-		if valueString(d["device_type"], "") != "firewall" {
+		if d.DeviceType != "firewall" {
 			continue
 		}
 		if deviceContainsIPInInterfaces(d, src) {
@@ -111,23 +107,22 @@ func resolveSourceFirewall(devs []map[string]any, src netip.Addr) map[string]any
 	}
 	if len(connected) > 0 {
 		sort.Slice(connected, func(i, j int) bool {
-			return valueString(connected[i]["logical_device_id"], "") < valueString(connected[j]["logical_device_id"], "")
+			return connected[i].LogicalDeviceID < connected[j].LogicalDeviceID
 		})
 		return connected[0]
 	}
 
 	bestBits := -1
-	var best map[string]any
+	var best *LogicalDevice
 	for _, d := range devs {
-		if valueString(d["device_type"], "") != "firewall" {
+		if d.DeviceType != "firewall" {
 			continue
 		}
 		r := longestRouteMatch(d, src, false)
 		if r.bits < 0 {
 			continue
 		}
-		if r.bits > bestBits || (r.bits == bestBits && best != nil &&
-			valueString(d["logical_device_id"], "") < valueString(best["logical_device_id"], "")) {
+		if r.bits > bestBits || (r.bits == bestBits && best != nil && d.LogicalDeviceID < best.LogicalDeviceID) {
 			bestBits = r.bits
 			best = d
 		}
@@ -135,19 +130,18 @@ func resolveSourceFirewall(devs []map[string]any, src netip.Addr) map[string]any
 	return best
 }
 
-func resolveDestinationFirewall(devs []map[string]any, dst netip.Addr) map[string]any {
+func resolveDestinationFirewall(devs []*LogicalDevice, dst netip.Addr) *LogicalDevice {
 	bestBits := -1
-	var best map[string]any
+	var best *LogicalDevice
 	for _, d := range devs {
-		if valueString(d["device_type"], "") != "firewall" {
+		if d.DeviceType != "firewall" {
 			continue
 		}
 		r := longestRouteMatch(d, dst, true)
 		if r.bits < 0 {
 			continue
 		}
-		if r.bits > bestBits || (r.bits == bestBits && best != nil &&
-			valueString(d["logical_device_id"], "") < valueString(best["logical_device_id"], "")) {
+		if r.bits > bestBits || (r.bits == bestBits && best != nil && d.LogicalDeviceID < best.LogicalDeviceID) {
 			bestBits = r.bits
 			best = d
 		}
@@ -161,7 +155,7 @@ type routeMatch struct {
 	usedDefault bool
 }
 
-func resolveEgressZone(dev map[string]any, dst netip.Addr) (string, bool) {
+func resolveEgressZone(dev *LogicalDevice, dst netip.Addr) (string, bool) {
 	r := longestRouteMatch(dev, dst, false)
 	if r.bits >= 0 {
 		return r.zone, r.usedDefault
@@ -173,42 +167,40 @@ func resolveEgressZone(dev map[string]any, dst netip.Addr) (string, bool) {
 	return "not_found", false
 }
 
-func longestRouteMatch(dev map[string]any, ip netip.Addr, includeDefault bool) routeMatch {
-	cur, _ := dev["current"].(map[string]any)
-	network, _ := cur["network"].(map[string]any)
-	cands := append(toAnySlice(network["routes_runtime"]), toAnySlice(network["routes_config"])...)
+func longestRouteMatch(dev *LogicalDevice, ip netip.Addr, includeDefault bool) routeMatch {
 	best := routeMatch{bits: -1, zone: "not_found", usedDefault: false}
-	for _, it := range cands {
-		r, _ := it.(map[string]any)
-		dst := valueString(r["destination"], "")
-		pfx, err := netip.ParsePrefix(dst)
+	for _, r := range dev.snapshot().Network.routes() {
+		pfx, err := netip.ParsePrefix(r.Destination)
 		if err != nil {
 			continue
 		}
-		if dst == "0.0.0.0/0" && !includeDefault {
+		if r.Destination == "0.0.0.0/0" && !includeDefault {
 			continue
 		}
 		if !pfx.Contains(ip) {
 			continue
 		}
 		if pfx.Bits() > best.bits {
-			best = routeMatch{bits: pfx.Bits(), zone: valueString(r["zone"], "not_found"), usedDefault: dst == "0.0.0.0/0"}
+			best = routeMatch{bits: pfx.Bits(), zone: valueString(r.Zone, "not_found"), usedDefault: r.Destination == "0.0.0.0/0"}
 		}
 	}
 	return best
 }
 
-func deviceContainsIPInInterfaces(dev map[string]any, ip netip.Addr) bool {
-	cur, _ := dev["current"].(map[string]any)
-	network, _ := cur["network"].(map[string]any)
-	ifaces := toAnySlice(network["interfaces"])
-	for _, it := range ifaces {
-		iface, _ := it.(map[string]any)
-		units := toAnySlice(iface["layer3_units"])
-		for _, u := range units {
-			unit, _ := u.(map[string]any)
-			for _, cidrAny := range toAnySlice(unit["ip_cidrs"]) {
-				cidr := valueString(cidrAny, "")
+func deviceContainsIPInInterfaces(dev *LogicalDevice, ip netip.Addr) bool {
+	network := dev.snapshot().Network
+	for _, r := range network.RoutesRuntime {
+		if r.Reason != "connected" {
+			continue
+		}
+		pfx, err := netip.ParsePrefix(r.Destination)
+		if err == nil && pfx.Contains(ip) {
+			return true
+		}
+	}
+	for _, iface := range network.Interfaces {
+		for _, unit := range iface.Layer3Units {
+			for _, cidr := range unit.IPCIDRs {
 				pfx, err := netip.ParsePrefix(cidr)
 				if err == nil && pfx.Contains(ip) {
 					return true
@@ -219,17 +211,14 @@ func deviceContainsIPInInterfaces(dev map[string]any, ip netip.Addr) bool {
 	return false
 }
 
-func findPathByTopology(state map[string]any, srcID, dstID string) []string {
+func findPathByTopology(state *State, srcID, dstID string) []string {
 	if srcID == dstID {
 		return []string{srcID}
 	}
-	topology, _ := state["topology"].(map[string]any)
-	edges := toAnySlice(topology["inferred_adjacencies"])
 	adj := map[string][]string{}
-	for _, e := range edges {
-		m, _ := e.(map[string]any)
-		aID := valueString(m["fw_a_logical_device_id"], "")
-		bID := valueString(m["fw_b_logical_device_id"], "")
+	for _, e := range state.Topology.InferredAdjacencies {
+		aID := e.FwALogicalDeviceID
+		bID := e.FwBLogicalDeviceID
 		if aID == "" || bID == "" {
 			continue
 		}
@@ -264,15 +253,6 @@ func findPathByTopology(state map[string]any, srcID, dstID string) []string {
 	return nil
 }
 
-func findDeviceByID(devs []map[string]any, id string) map[string]any {
-	for _, d := range devs {
-		if valueString(d["logical_device_id"], "") == id {
-			return d
-		}
-	}
-	return map[string]any{}
-}
-
 func buildMermaid(hops []flowHop) string {
 	lines := []string{"flowchart LR"}
 	for i, h := range hops {
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rebuildIngestIndex repopulates app.ingests on startup: completed ingests
+// from every environment's ingest.ndjson (including trashed ones), and
+// ingests paused on an RMA prompt from runtime/ingests/*.json so that
+// handleRmaDecision can resume them. Ingests that were queued or running
+// when the previous process stopped left no trace and cannot be recovered,
+// so their spooled uploads are discarded.
+func (a *app) rebuildIngestIndex() {
+	_ = os.RemoveAll(filepath.Join(a.storage, "runtime", "uploads"))
+
+	for _, root := range []string{"environments", "trash"} {
+		entries, err := os.ReadDir(filepath.Join(a.storage, root))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			a.indexIngestLog(filepath.Join(a.storage, root, e.Name(), "ingest.ndjson"))
+		}
+	}
+
+	dir := filepath.Join(a.storage, "runtime", "ingests")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		st := readRuntimeIngest(filepath.Join(dir, e.Name()))
+		if st == nil {
+			continue
+		}
+		if _, done := a.getIngest(st.IngestID); done {
+			// A final record exists, so the runtime file is a leftover.
+			_ = a.removeRuntimeIngest(st.IngestID)
+			continue
+		}
+		a.storeIngest(st)
+	}
+}
+
+func (a *app) indexIngestLog(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if json.Unmarshal([]byte(line), &rec) != nil {
+			continue
+		}
+		ingestID := valueString(rec["ingest_id"], "")
+		if ingestID == "" {
+			continue
+		}
+		st := &ingestStatus{
+			IngestID:    ingestID,
+			EnvID:       valueString(rec["env_id"], ""),
+			Status:      "completed",
+			Stage:       "persist",
+			Progress:    ingestProgress{Pct: 100, Message: "completed"},
+			FinalRecord: rec,
+			ArchiveSHA:  valueString(rec["fingerprint_sha256"], "not_found"),
+		}
+		if ingestErr, _ := rec["error"].(map[string]any); ingestErr != nil {
+			st.Progress.Message = "completed with error"
+		}
+		st.StartedAt, _ = time.Parse(time.RFC3339, valueString(rec["started_at"], ""))
+		if src, _ := rec["source"].(map[string]any); src != nil {
+			st.SourceMode = valueString(src["mode"], "file")
+			if names := toAnySlice(src["filenames"]); len(names) > 0 {
+				st.Filename = valueString(names[0], "")
+			}
+		}
+		a.storeIngest(st)
+	}
+}
+
+// readRuntimeIngest restores an awaiting_user ingest from its runtime file
+// (§9.4). Older files without the resume fields still come back with their
+// candidates; the final record then just lacks filename and fingerprint.
+func readRuntimeIngest(path string) *ingestStatus {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var payload map[string]any
+	if json.Unmarshal(b, &payload) != nil {
+		return nil
+	}
+	ingestID := valueString(payload["ingest_id"], "")
+	envID := valueString(payload["env_id"], "")
+	if ingestID == "" || envID == "" {
+		return nil
+	}
+	extracted, _ := payload["extracted_payload"].(map[string]any)
+	candidates := toAnySlice(payload["rma_candidates"])
+	st := &ingestStatus{
+		IngestID:    ingestID,
+		EnvID:       envID,
+		Status:      "awaiting_user",
+		Stage:       "awaiting_user",
+		Progress:    ingestProgress{Pct: 80, Message: "awaiting user"},
+		RMAPrompt:   map[string]any{"required": true, "candidates": candidates},
+		PendingData: map[string]any{"extracted": extracted},
+		Durations:   map[string]int64{},
+		ArchiveSHA:  valueString(payload["fingerprint_sha256"], "not_found"),
+		Filename:    valueString(payload["filename"], ""),
+		Extracted:   extracted,
+		SourceMode:  valueString(payload["source_mode"], "file"),
+	}
+	st.ctx, st.cancel = context.WithCancel(context.Background())
+	st.StartedAt, _ = time.Parse(time.RFC3339, valueString(payload["started_at"], ""))
+	st.StageStart = st.StartedAt
+	if t, err := time.Parse(time.RFC3339Nano, valueString(payload["stage_started_at"], "")); err == nil {
+		st.StageStart = t
+	}
+	for _, w := range toAnySlice(payload["warnings"]) {
+		if v := valueString(w, ""); v != "" {
+			st.Warnings = append(st.Warnings, v)
+		}
+	}
+	durations, _ := payload["duration_ms_by_stage"].(map[string]any)
+	for stage, v := range durations {
+		if ms, ok := v.(float64); ok {
+			st.Durations[stage] = int64(ms)
+		}
+	}
+	return st
+}
@@ -1,20 +1,19 @@
 package main
 
 import (
-	"archive/tar"
 	"bufio"
-	"bytes"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/netip"
 	"os"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -26,23 +25,27 @@ type ingestProgress struct {
 }
 
 type ingestStatus struct {
-	IngestID    string                 `json:"ingest_id"`
-	EnvID       string                 `json:"env_id"`
-	Status      string                 `json:"status"`
-	Stage       string                 `json:"stage"`
-	Progress    ingestProgress         `json:"progress"`
-	FinalRecord map[string]any         `json:"final_record,omitempty"`
-	RMAPrompt   map[string]any         `json:"rma_prompt,omitempty"`
-	PendingData map[string]any         `json:"-"`
-	StartedAt   time.Time              `json:"-"`
-	StageStart  time.Time              `json:"-"`
-	Durations   map[string]int64       `json:"-"`
-	ArchiveSHA  string                 `json:"-"`
-	Filename    string                 `json:"-"`
-	Files       map[string]string      `json:"-"`
-	Extracted   map[string]any         `json:"-"`
-	StatePatch  map[string]interface{} `json:"-"`
-	SourceMode  string                 `json:"-"`
+	IngestID      string                 `json:"ingest_id"`
+	EnvID         string                 `json:"env_id"`
+	Status        string                 `json:"status"`
+	Stage         string                 `json:"stage"`
+	Progress      ingestProgress         `json:"progress"`
+	QueuePosition int                    `json:"queue_position,omitempty"`
+	FinalRecord   map[string]any         `json:"final_record,omitempty"`
+	RMAPrompt     map[string]any         `json:"rma_prompt,omitempty"`
+	PendingData   map[string]any         `json:"-"`
+	StartedAt     time.Time              `json:"-"`
+	StageStart    time.Time              `json:"-"`
+	Durations     map[string]int64       `json:"-"`
+	ArchiveSHA    string                 `json:"-"`
+	Filename      string                 `json:"-"`
+	Extracted     map[string]any         `json:"-"`
+	StatePatch    map[string]interface{} `json:"-"`
+	SourceMode    string                 `json:"-"`
+	Warnings      []string               `json:"-"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type createIngestResponse struct {
@@ -65,41 +68,133 @@ func (a *app) handleCreateIngest(w http.ResponseWriter, r *http.Request, envID s
 		return
 	}
 
-	if err := r.ParseMultipartForm(64 << 20); err != nil {
+	mr, err := r.MultipartReader()
+	if err != nil {
 		writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "invalid multipart upload")
 		return
 	}
-
-	f, fh, err := r.FormFile("file")
-	if err != nil {
+	var part *multipart.Part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "invalid multipart upload")
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+		_ = p.Close()
+	}
+	if part == nil {
 		writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "file field is required")
 		return
 	}
-	defer f.Close()
+	defer part.Close()
 
-	contents, err := io.ReadAll(io.LimitReader(f, 256<<20))
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "ERR_INVALID_ARCHIVE", "failed to read upload")
+	st := newIngestStatus(envID, part.FileName(), ingestSourceMode(r))
+	a.storeIngest(st)
+	upload, recvErr := a.spoolUpload(st, part)
+	if recvErr != nil && recvErr.Code == "ERR_ARCHIVE_TOO_LARGE" {
+		a.failScan(envDir, st, recvErr)
+		writeError(w, http.StatusRequestEntityTooLarge, "ERR_ARCHIVE_TOO_LARGE", fmt.Sprintf("archive exceeds the %d byte upload cap", maxArchiveBytes))
 		return
 	}
+	a.enqueueIngest(&ingestJob{envDir: envDir, st: st, upload: upload, scanErr: recvErr})
+	writeJSON(w, http.StatusAccepted, createIngestResponse{IngestID: st.IngestID})
+}
+
+func (a *app) uploadSpoolPath(ingestID string) string {
+	return filepath.Join(a.storage, "runtime", "uploads", ingestID+".tgz")
+}
+
+// spoolUpload copies an uploaded archive to runtime/uploads so the request
+// can return the ingest_id before the archive is scanned on its
+// environment's queue. completeIngest removes the file.
+func (a *app) spoolUpload(st *ingestStatus, src io.Reader) (string, *scanError) {
+	path := a.uploadSpoolPath(st.IngestID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", &scanError{Stage: "receive", Code: "ERR_PERSIST_FAILED", Msg: "failed to spool upload"}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", &scanError{Stage: "receive", Code: "ERR_PERSIST_FAILED", Msg: "failed to spool upload"}
+	}
+	capped := &cappedReader{r: src, max: maxArchiveBytes}
+	_, err = io.Copy(f, capped)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		return path, nil
+	}
+	_ = os.Remove(path)
+	if capped.exceeded {
+		return "", &scanError{Stage: "receive", Code: "ERR_ARCHIVE_TOO_LARGE", Msg: "upload exceeds the archive size cap"}
+	}
+	return "", &scanError{Stage: "receive", Code: "ERR_INVALID_ARCHIVE", Msg: "failed to receive upload"}
+}
 
-	ingestID := newUUID()
-	st := &ingestStatus{
-		IngestID:   ingestID,
+func newIngestStatus(envID, filename, sourceMode string) *ingestStatus {
+	now := time.Now().UTC()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ingestStatus{
+		ctx:        ctx,
+		cancel:     cancel,
+		IngestID:   newUUID(),
 		EnvID:      envID,
 		Status:     "running",
 		Stage:      "receive",
-		Progress:   ingestProgress{Pct: 5, Message: "received upload"},
-		StartedAt:  time.Now().UTC(),
-		StageStart: time.Now().UTC(),
+		Progress:   ingestProgress{Pct: 5, Message: "receiving upload"},
+		StartedAt:  now,
+		StageStart: now,
 		Durations:  map[string]int64{},
-		Filename:   filepath.Base(fh.Filename),
-		ArchiveSHA: hashBytes(contents),
-		SourceMode: ingestSourceMode(r),
+		ArchiveSHA: "not_found",
+		Filename:   filepath.Base(filename),
+		SourceMode: sourceMode,
+	}
+}
+
+// scanQueuedIngest runs on the environment's queue: it scans the job's
+// archive and carries the scan straight on into processIngest.
+func (a *app) scanQueuedIngest(job *ingestJob) {
+	fh, err := os.Open(job.upload)
+	if err != nil {
+		a.failScan(job.envDir, job.st, &scanError{Stage: "receive", Code: "ERR_INVALID_ARCHIVE", Msg: "failed to open archive"})
+		return
+	}
+	scan, scanErr := a.scanIngest(job.st, fh)
+	_ = fh.Close()
+	if scanErr != nil {
+		a.failScan(job.envDir, job.st, scanErr)
+		return
+	}
+	a.processIngest(job.envDir, job.st, scan, nil)
+}
+
+// scanIngest streams one archive through the scanner, keeping only the small
+// scan result, never the archive bytes.
+func (a *app) scanIngest(st *ingestStatus, src io.Reader) (*tsfScan, *scanError) {
+	a.setStage(st, "scan", 15, "scanning archive")
+	scan, fingerprint, scanErr := scanArchive(st.ctx, src)
+	st.ArchiveSHA = fingerprint
+	if scan != nil {
+		st.Warnings = scan.Warnings
+	}
+	if st.ArchiveSHA == "" {
+		st.ArchiveSHA = "not_found"
 	}
 	a.storeIngest(st)
-	a.processIngest(envDir, st, contents, nil)
-	writeJSON(w, http.StatusAccepted, createIngestResponse{IngestID: ingestID})
+	return scan, scanErr
+}
+
+func (a *app) failScan(envDir string, st *ingestStatus, scanErr *scanError) {
+	st.Stage = scanErr.Stage
+	final := finalizeRecord(st, "error", map[string]any{"stage": scanErr.Stage, "code": scanErr.Code, "message": scanErr.Msg})
+	a.completeIngest(envDir, st, final, "completed with error")
 }
 
 func (a *app) handleGetIngestStatus(w http.ResponseWriter, ingestID string) {
@@ -136,81 +231,95 @@ func (a *app) handleRmaDecision(w http.ResponseWriter, r *http.Request, ingestID
 		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
 		return
 	}
+	paused, ok := a.claimPausedIngest(ingestID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_INGEST_NOT_FOUND", "ingest not awaiting user input")
+		return
+	}
 	decision := map[string]any{"decision": req.Decision, "target_logical_device_id": req.TargetLogicalDeviceID}
-	a.processIngest(envDir, &st, nil, decision)
-	writeJSON(w, http.StatusOK, map[string]any{"ingest_id": st.IngestID, "status": st.Status})
+	a.enqueueIngest(&ingestJob{envDir: envDir, st: paused, decision: decision})
+	writeJSON(w, http.StatusOK, map[string]any{"ingest_id": ingestID, "status": "queued"})
 }
 
-func (a *app) processIngest(envDir string, st *ingestStatus, contents []byte, decision map[string]any) {
-	stageDurations := map[string]int64{}
-	setStage := func(stage string, pct int, msg string) {
-		now := time.Now().UTC()
-		stageDurations[st.Stage] += now.Sub(st.StageStart).Milliseconds()
-		st.Stage = stage
-		st.StageStart = now
-		st.Progress = ingestProgress{Pct: pct, Message: msg}
+func (a *app) setStage(st *ingestStatus, stage string, pct int, msg string) {
+	now := time.Now().UTC()
+	st.Durations[st.Stage] += now.Sub(st.StageStart).Milliseconds()
+	st.Stage = stage
+	st.StageStart = now
+	st.Progress = ingestProgress{Pct: pct, Message: msg}
+	a.storeIngest(st)
+	a.publishIngestEvent(st)
+}
+
+// completeIngest appends the final record to ingest.ndjson and publishes the
+// terminal status; any runtime file left by an RMA prompt is removed.
+func (a *app) completeIngest(envDir string, st *ingestStatus, final map[string]any, msg string) {
+	_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
+	_ = a.removeRuntimeIngest(st.IngestID)
+	_ = os.Remove(a.uploadSpoolPath(st.IngestID))
+	st.Status = "completed"
+	st.Stage = "persist"
+	st.Progress = ingestProgress{Pct: 100, Message: msg}
+	st.FinalRecord = final
+	st.RMAPrompt = nil
+	st.PendingData = nil
+	if st.cancel != nil {
+		st.cancel()
 	}
+	a.storeIngest(st)
+	a.publishIngestEvent(st)
+}
 
+func (a *app) processIngest(envDir string, st *ingestStatus, scan *tsfScan, decision map[string]any) {
+	if st.Durations == nil {
+		st.Durations = map[string]int64{}
+	}
 	if decision == nil {
-		setStage("scan", 15, "scanning archive")
-		files, err := scanTarGz(contents)
-		if err != nil {
-			final := finalizeRecord(st, stageDurations, "error", map[string]any{"stage": "scan", "code": "ERR_INVALID_ARCHIVE", "message": "archive is not a readable gzip/tar"})
-			st.Status = "completed"
-			st.Stage = "persist"
-			st.Progress = ingestProgress{Pct: 100, Message: "completed with error"}
-			st.FinalRecord = final
-			_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-			a.storeIngest(st)
+		a.setStage(st, "identify", 35, "identifying device type")
+		if a.abortIfCanceled(envDir, st, nil) {
 			return
 		}
-		st.Files = files
-
-		setStage("identify", 35, "identifying device type")
-		extracted := extractFields(files)
+		extracted := extractFields(scan)
 		st.Extracted = extracted
 
-		setStage("extract", 55, "extracting normalized fields")
-		setStage("derive", 70, "deriving candidate state")
+		a.setStage(st, "extract", 55, "extracting normalized fields")
+		if a.abortIfCanceled(envDir, st, extracted) {
+			return
+		}
+		if err := applyConfigExtraction(scan, extracted); err != nil {
+			final := finalizeRecord(st, "error", map[string]any{"stage": "extract", "code": "ERR_PARSE_XML_FAILED", "message": "config XML parsing failed"})
+			populateDeviceFromExtracted(final, extracted)
+			a.completeIngest(envDir, st, final, "completed with error")
+			return
+		}
+
+		a.setStage(st, "derive", 70, "deriving candidate state")
+		if a.abortIfCanceled(envDir, st, extracted) {
+			return
+		}
 
 		if isDuplicate(envDir, st.ArchiveSHA) {
-			final := finalizeRecord(st, stageDurations, "duplicate", nil)
+			final := finalizeRecord(st, "duplicate", nil)
 			populateDeviceFromExtracted(final, extracted)
-			st.Status = "completed"
-			st.Stage = "persist"
-			st.Progress = ingestProgress{Pct: 100, Message: "completed (duplicate)"}
-			st.FinalRecord = final
-			_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-			a.storeIngest(st)
+			a.completeIngest(envDir, st, final, "completed (duplicate)")
 			return
 		}
 
-		state, err := loadState(envDir)
+		state, err := a.loadState(envDir)
 		if err != nil {
-			final := finalizeRecord(st, stageDurations, "error", map[string]any{"stage": "derive", "code": "ERR_PERSIST_FAILED", "message": "failed to load state"})
+			final := finalizeRecord(st, "error", map[string]any{"stage": "derive", "code": "ERR_PERSIST_FAILED", "message": "failed to load state"})
 			populateDeviceFromExtracted(final, extracted)
-			st.Status = "completed"
-			st.Stage = "persist"
-			st.Progress = ingestProgress{Pct: 100, Message: "completed with error"}
-			st.FinalRecord = final
-			_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-			a.storeIngest(st)
+			a.completeIngest(envDir, st, final, "completed with error")
 			return
 		}
 
 		candidates := findRMACandidates(state, extracted)
 		if len(candidates) > 0 {
-			setStage("awaiting_user", 80, "awaiting RMA decision")
 			st.Status = "awaiting_user"
-			st.Stage = "awaiting_user"
-			st.Progress = ingestProgress{Pct: 80, Message: "awaiting user"}
 			st.RMAPrompt = map[string]any{"required": true, "candidates": candidates}
-			st.PendingData = map[string]any{
-				"extracted": extracted,
-				"durations": stageDurations,
-			}
+			st.PendingData = map[string]any{"extracted": extracted}
+			a.setStage(st, "awaiting_user", 80, "awaiting user")
 			_ = a.writeRuntimeIngest(st, candidates)
-			a.storeIngest(st)
 			return
 		}
 		decision = map[string]any{"decision": "treat_as_new_device", "target_logical_device_id": ""}
@@ -231,53 +340,47 @@ func (a *app) processIngest(envDir string, st *ingestStatus, contents []byte, de
 	}
 
 	if decisionValue(decision) == "canceled" {
-		final := finalizeRecord(st, stageDurations, "error", map[string]any{
+		final := finalizeRecord(st, "error", map[string]any{
 			"stage":   "awaiting_user",
 			"code":    "ERR_USER_ABORTED",
 			"message": "user canceled RMA decision",
 		})
 		populateDeviceFromExtracted(final, extracted)
 		addRMARecord(final, true, "canceled")
-		_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-		_ = a.removeRuntimeIngest(st.IngestID)
-		st.Status = "completed"
-		st.Stage = "persist"
-		st.Progress = ingestProgress{Pct: 100, Message: "completed with user abort"}
-		st.FinalRecord = final
-		st.RMAPrompt = nil
-		st.PendingData = nil
-		a.storeIngest(st)
+		a.completeIngest(envDir, st, final, "completed with user abort")
 		return
 	}
 
-	setStage("diff", 85, "computing canonical diff")
-	state, err := loadState(envDir)
+	a.setStage(st, "diff", 85, "computing canonical diff")
+	if a.abortIfCanceled(envDir, st, extracted) {
+		return
+	}
+	state, err := a.loadState(envDir)
 	if err != nil {
-		final := finalizeRecord(st, stageDurations, "error", map[string]any{"stage": "diff", "code": "ERR_PERSIST_FAILED", "message": "failed to load state"})
+		final := finalizeRecord(st, "error", map[string]any{"stage": "diff", "code": "ERR_PERSIST_FAILED", "message": "failed to load state"})
 		populateDeviceFromExtracted(final, extracted)
 		addRMARecord(final, true, decisionValue(decision))
-		st.Status = "completed"
-		st.Stage = "persist"
-		st.Progress = ingestProgress{Pct: 100, Message: "completed with error"}
-		st.FinalRecord = final
-		_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-		a.storeIngest(st)
+		a.completeIngest(envDir, st, final, "completed with error")
 		return
 	}
 
 	beforeHash, _ := hashCanonical(state)
+	beforeDoc, _ := canonicalCopy(state)
 	logicalID, newState := applyExtractedState(state, st, extracted, decision)
 	a.sortState(newState)
 	a.applyTopology(newState)
 	afterHash, _ := hashCanonical(newState)
 
-	setStage("persist", 95, "persisting state and logs")
+	a.setStage(st, "persist", 95, "persisting state and logs")
+	if a.abortIfCanceled(envDir, st, extracted) {
+		return
+	}
 	statusCode := "success"
 	if beforeHash == afterHash {
 		statusCode = "no_change"
 	}
 	a.writeIntro(envDir, newState, statusCode, time.Now().UTC().Format(time.RFC3339))
-	final := finalizeRecord(st, stageDurations, statusCode, nil)
+	final := finalizeRecord(st, statusCode, nil)
 	populateDeviceFromExtracted(final, extracted)
 	if st.PendingData != nil {
 		addRMARecord(final, true, decisionValue(decision))
@@ -285,43 +388,47 @@ func (a *app) processIngest(envDir string, st *ingestStatus, contents []byte, de
 
 	if statusCode == "success" {
 		if err := a.writeStateAtomic(envDir, newState); err != nil {
-			final = finalizeRecord(st, stageDurations, "error", map[string]any{"stage": "persist", "code": "ERR_PERSIST_FAILED", "message": "failed to persist state"})
+			ingestErr := map[string]any{"stage": "persist", "code": "ERR_PERSIST_FAILED", "message": "failed to persist state"}
+			var invalid *stateInvalidError
+			if errors.As(err, &invalid) {
+				ingestErr["code"] = "ERR_STATE_INVALID"
+				ingestErr["message"] = invalid.Error()
+			}
+			final = finalizeRecord(st, "error", ingestErr)
 			populateDeviceFromExtracted(final, extracted)
 			if st.PendingData != nil {
 				addRMARecord(final, true, decisionValue(decision))
 			}
 		} else {
 			commitID := newUUID()
+			afterDoc, _ := canonicalCopy(newState)
+			// Environments that predate snapshots get their prior state
+			// captured on the first commit, so ?at= can reach back before it.
+			_, _ = writeStateSnapshot(envDir, beforeDoc)
 			commit := map[string]any{
 				"commit_id":         commitID,
 				"env_id":            st.EnvID,
 				"ingest_id":         st.IngestID,
 				"timestamp":         time.Now().UTC().Format(time.RFC3339),
 				"source_summary":    st.Filename,
-				"change_summary":    []string{"device inventory updated"},
-				"change_paths":      []string{"/devices/logical", "/topology/inferred_adjacencies"},
 				"state_hash_before": beforeHash,
 				"state_hash_after":  afterHash,
 			}
-			_ = writeNDJSONLine(filepath.Join(envDir, "commits.ndjson"), commit)
+			_ = recordStateCommit(envDir, commit, beforeDoc, afterDoc)
 			final["result"] = map[string]any{"commit_id": commitID, "state_hash_after": afterHash}
 			_ = logicalID
 		}
 	}
 
-	_ = writeNDJSONLine(filepath.Join(envDir, "ingest.ndjson"), final)
-	_ = a.removeRuntimeIngest(st.IngestID)
-	st.Status = "completed"
-	st.Stage = "persist"
-	st.Progress = ingestProgress{Pct: 100, Message: "completed"}
-	st.FinalRecord = final
-	st.RMAPrompt = nil
-	st.PendingData = nil
-	a.storeIngest(st)
+	a.completeIngest(envDir, st, final, "completed")
 }
 
-func finalizeRecord(st *ingestStatus, stageDurations map[string]int64, status string, ingestErr map[string]any) map[string]any {
+func finalizeRecord(st *ingestStatus, status string, ingestErr map[string]any) map[string]any {
 	now := time.Now().UTC()
+	stageDurations := map[string]int64{}
+	for k, v := range st.Durations {
+		stageDurations[k] = v
+	}
 	stageDurations[st.Stage] += now.Sub(st.StageStart).Milliseconds()
 	for _, stage := range []string{"receive", "scan", "identify", "extract", "derive", "diff", "persist", "awaiting_user"} {
 		if _, ok := stageDurations[stage]; !ok {
@@ -347,6 +454,9 @@ func finalizeRecord(st *ingestStatus, stageDurations map[string]int64, status st
 	if ingestErr != nil {
 		rec["error"] = ingestErr
 	}
+	if len(st.Warnings) > 0 {
+		rec["warnings"] = st.Warnings
+	}
 	if st.SourceMode == "batch" {
 		rec["source"] = map[string]any{"mode": "batch", "filenames": []string{st.Filename}}
 	}
@@ -360,71 +470,39 @@ func maxInt64(v int64, min int64) int64 {
 	return v
 }
 
-func scanTarGz(contents []byte) (map[string]string, error) {
-	gz, err := gzip.NewReader(bytes.NewReader(contents))
-	if err != nil {
-		return nil, err
-	}
-	defer gz.Close()
-	tr := tar.NewReader(gz)
-	files := map[string]string{}
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
+func extractFields(scan *tsfScan) map[string]any {
+	secs := scan.CLI
+	identity, sources := extractCLIIdentity(secs)
+	serial := identity["serial"]
+	hostname := identity["hostname"]
+	model := identity["model"]
+
+	managed := map[string]struct{}{}
+	for _, s := range scan.ManagedSerials {
+		managed[s] = struct{}{}
+	}
+	configPanorama := false
+	for _, cfg := range scan.Configs {
+		if isPanoramaConfig(cfg) {
+			configPanorama = true
 		}
-		if err != nil {
-			return nil, err
-		}
-		if hdr.Typeflag != tar.TypeReg {
-			continue
-		}
-		b, err := io.ReadAll(io.LimitReader(tr, 2<<20))
-		if err != nil {
-			return nil, err
+		for _, e := range cfg.path("mgt-config", "devices").entries() {
+			if name := e.attr("name"); name != "" {
+				managed[name] = struct{}{}
+			}
 		}
-		files[hdr.Name] = string(b)
 	}
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no files in archive")
+	managedSerials := make([]string, 0, len(managed))
+	for s := range managed {
+		managedSerials = append(managedSerials, s)
 	}
-	return files, nil
-}
-
-func extractFields(files map[string]string) map[string]any {
-	var text strings.Builder
-	isPanorama := false
-	for name, body := range files {
-		lower := strings.ToLower(name + "\n" + body)
-		if strings.Contains(lower, "panorama") || strings.Contains(lower, "device-group") {
-			isPanorama = true
-		}
-		text.WriteString("\n")
-		text.WriteString(body)
-	}
-	all := text.String()
-
-	serial := firstMatch(all,
-		`(?im)\bserial(?: number)?\s*[:=]\s*([A-Za-z0-9._-]+)`,
-		`(?im)\bchassis[-_ ]serial\s*[:=]\s*([A-Za-z0-9._-]+)`)
-	hostname := firstMatch(all,
-		`(?im)\bhostname\s*[:=]\s*([A-Za-z0-9._-]+)`,
-		`(?im)^set\s+deviceconfig\s+system\s+hostname\s+([A-Za-z0-9._-]+)`)
-	model := firstMatch(all, `(?im)\bmodel\s*[:=]\s*([A-Za-z0-9._-]+)`)
-	panos := firstMatch(all,
-		`(?im)\b(?:pan-?os|sw[-_ ]?version|version)\s*[:=]\s*([A-Za-z0-9._-]+)`)
-	mgmtIP := firstMatch(all, `(?im)\b(?:mgmt|management)[-_ ]?ip\s*[:=]\s*([0-9]{1,3}(?:\.[0-9]{1,3}){3})`)
-	if mgmtIP == "not_found" {
-		mgmtIP = firstMatch(all, `(?im)\b([0-9]{1,3}(?:\.[0-9]{1,3}){3})\b`)
-	}
-
-	managedSerials := findAllMatches(all, `(?im)\bmanaged[_ -]?serial\s*[:=]\s*([A-Za-z0-9._-]+)`)
 	sort.Strings(managedSerials)
-	routesRuntime := extractRoutes(all, "runtime")
-	routesConfig := extractRoutes(all, "config")
 
 	deviceType := "firewall"
-	if isPanorama {
+	switch {
+	case strings.EqualFold(model, "panorama") || strings.EqualFold(identity["system_mode"], "management-only"):
+		deviceType = "panorama"
+	case model == "not_found" && identity["system_mode"] == "not_found" && configPanorama:
 		deviceType = "panorama"
 	}
 	if serial == "not_found" && hostname == "not_found" {
@@ -436,63 +514,15 @@ func extractFields(files map[string]string) map[string]any {
 		"serial":                 serial,
 		"hostname":               hostname,
 		"model":                  model,
-		"panos_version":          panos,
-		"mgmt_ip":                mgmtIP,
+		"panos_version":          identity["panos_version"],
+		"mgmt_ip":                identity["mgmt_ip"],
+		"cloud_mode":             identity["cloud_mode"],
+		"field_sources":          sources,
+		"licenses":               extractLicenses(secs),
+		"ha":                     extractCLIHA(secs),
 		"managed_device_serials": managedSerials,
-		"routes_runtime":         routesRuntime,
-		"routes_config":          routesConfig,
-	}
-}
-
-func extractRoutes(all string, sourceType string) []map[string]any {
-	cidrs := findAllMatches(all, `(?im)\b([0-9]{1,3}(?:\.[0-9]{1,3}){3}/[0-9]{1,2})\b`)
-	out := make([]map[string]any, 0, len(cidrs))
-	for _, cidr := range cidrs {
-		reason := "configured"
-		if strings.Contains(all, "connected:"+cidr) || strings.Contains(all, "connected "+cidr) {
-			reason = "connected"
-		}
-		out = append(out, map[string]any{
-			"vr":          "not_found",
-			"destination": cidr,
-			"nexthop":     "not_found",
-			"interface":   "not_found",
-			"metric":      "not_found",
-			"reason":      reason,
-			"source_type": sourceType,
-			"source_path": "not_found",
-		})
-	}
-	sort.Slice(out, func(i, j int) bool {
-		return valueString(out[i]["destination"], "") < valueString(out[j]["destination"], "")
-	})
-	return out
-}
-
-func firstMatch(s string, patterns ...string) string {
-	for _, p := range patterns {
-		re := regexp.MustCompile(p)
-		m := re.FindStringSubmatch(s)
-		if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
-			return strings.TrimSpace(m[1])
-		}
-	}
-	return "not_found"
-}
-
-func findAllMatches(s, p string) []string {
-	re := regexp.MustCompile(p)
-	found := map[string]struct{}{}
-	for _, m := range re.FindAllStringSubmatch(s, -1) {
-		if len(m) > 1 && strings.TrimSpace(m[1]) != "" {
-			found[strings.TrimSpace(m[1])] = struct{}{}
-		}
+		"routes_runtime":         extractRuntimeRoutes(secs),
 	}
-	out := make([]string, 0, len(found))
-	for v := range found {
-		out = append(out, v)
-	}
-	return out
 }
 
 func populateDeviceFromExtracted(record map[string]any, extracted map[string]any) {
@@ -546,22 +576,6 @@ func toAnySlice(v any) []any {
 	}
 }
 
-func loadState(envDir string) (map[string]any, error) {
-	path := filepath.Join(envDir, "state.json")
-	b, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return map[string]any{"schema_version": "1.0.0", "generated_at": time.Now().UTC().Format(time.RFC3339), "env": map[string]any{"env_id": filepath.Base(envDir), "name": "unknown"}, "devices": map[string]any{"logical": []map[string]any{}}, "topology": map[string]any{"inferred_adjacencies": []map[string]any{}}}, nil
-		}
-		return nil, err
-	}
-	var state map[string]any
-	if err := json.Unmarshal(b, &state); err != nil {
-		return nil, err
-	}
-	return state, nil
-}
-
 func isDuplicate(envDir, hash string) bool {
 	path := filepath.Join(envDir, "ingest.ndjson")
 	f, err := os.Open(path)
@@ -586,22 +600,20 @@ func isDuplicate(envDir, hash string) bool {
 	return false
 }
 
-func findRMACandidates(state map[string]any, extracted map[string]any) []map[string]any {
+func findRMACandidates(state *State, extracted map[string]any) []map[string]any {
 	hostname := valueString(extracted["hostname"], "not_found")
 	serial := valueString(extracted["serial"], "not_found")
 	if hostname == "not_found" || serial == "not_found" {
 		return nil
 	}
-	logical := logicalDevices(state)
 	out := make([]map[string]any, 0)
-	for _, d := range logical {
-		current, _ := d["current"].(map[string]any)
-		identity, _ := current["identity"].(map[string]any)
-		h := valueString(identity["hostname"], "not_found")
-		s := valueString(identity["serial"], "not_found")
+	for _, d := range state.Devices.Logical {
+		identity := d.snapshot().Identity
+		h := valueString(identity.Hostname, "not_found")
+		s := valueString(identity.Serial, "not_found")
 		if h == hostname && s != "not_found" && s != serial {
 			out = append(out, map[string]any{
-				"logical_device_id": valueString(d["logical_device_id"], ""),
+				"logical_device_id": d.LogicalDeviceID,
 				"current_serial":    s,
 				"current_hostname":  h,
 			})
@@ -610,66 +622,39 @@ func findRMACandidates(state map[string]any, extracted map[string]any) []map[str
 	return out
 }
 
-func logicalDevices(state map[string]any) []map[string]any {
-	devices, _ := state["devices"].(map[string]any)
-	if devices == nil {
-		devices = map[string]any{}
-		state["devices"] = devices
-	}
-	arr, _ := devices["logical"].([]any)
-	out := make([]map[string]any, 0, len(arr))
-	for _, it := range arr {
-		m, _ := it.(map[string]any)
-		if m != nil {
-			out = append(out, m)
-		}
-	}
-	return out
-}
-
-func applyExtractedState(state map[string]any, st *ingestStatus, extracted map[string]any, decision map[string]any) (string, map[string]any) {
+func applyExtractedState(state *State, st *ingestStatus, extracted map[string]any, decision map[string]any) (string, *State) {
 	now := time.Now().UTC().Format(time.RFC3339)
 
 	serial := valueString(extracted["serial"], "not_found")
 	deviceType := valueString(extracted["device_type"], "unknown")
 
-	logical := logicalDevices(state)
 	targetID := valueString(decision["target_logical_device_id"], "")
 	d := valueString(decision["decision"], "treat_as_new_device")
 
-	findByID := func(id string) map[string]any {
-		for _, dev := range logical {
-			if valueString(dev["logical_device_id"], "") == id {
-				return dev
-			}
-		}
-		return nil
-	}
-	findBySerial := func(s string) map[string]any {
-		for _, dev := range logical {
-			cur, _ := dev["current"].(map[string]any)
-			idn, _ := cur["identity"].(map[string]any)
-			if valueString(idn["serial"], "") == s {
+	findBySerial := func(s string) *LogicalDevice {
+		for _, dev := range state.Devices.Logical {
+			if dev.snapshot().Identity.Serial == s {
 				return dev
 			}
 		}
 		return nil
 	}
 
-	var target map[string]any
+	var target *LogicalDevice
 	if d == "link_replacement" && targetID != "" {
-		target = findByID(targetID)
+		target = state.deviceByID(targetID)
 	}
 	if target == nil {
 		target = findBySerial(serial)
 	}
+	added := false
 	if target == nil && d != "link_replacement" {
-		target = map[string]any{
-			"logical_device_id": newUUID(),
-			"device_type":       mapDeviceType(deviceType),
-			"serial_history":    []any{},
+		target = &LogicalDevice{
+			LogicalDeviceID: newUUID(),
+			DeviceType:      mapDeviceType(deviceType),
+			SerialHistory:   []SerialHistoryItem{},
 		}
-		logical = append(logical, target)
+		added = true
 	}
 
 	if target == nil {
@@ -677,35 +662,43 @@ func applyExtractedState(state map[string]any, st *ingestStatus, extracted map[s
 		return "", state
 	}
 
-	if d != "link_replacement" && target != nil && serial != "not_found" {
-		cur, _ := target["current"].(map[string]any)
-		idn, _ := cur["identity"].(map[string]any)
-		if valueString(target["device_type"], "") == mapDeviceType(deviceType) &&
-			valueString(idn["hostname"], "not_found") == valueString(extracted["hostname"], "not_found") &&
-			valueString(idn["serial"], "not_found") == valueString(extracted["serial"], "not_found") &&
-			valueString(idn["model"], "not_found") == valueString(extracted["model"], "not_found") &&
-			valueString(idn["panos_version"], "not_found") == valueString(extracted["panos_version"], "not_found") &&
-			valueString(idn["mgmt_ip"], "not_found") == valueString(extracted["mgmt_ip"], "not_found") {
-			return valueString(target["logical_device_id"], ""), state
+	snapshot := buildCurrentSnapshot(st, extracted)
+	if d != "link_replacement" && serial != "not_found" {
+		if target.DeviceType == mapDeviceType(deviceType) && sameSnapshotContent(target.Current, snapshot) {
+			return target.LogicalDeviceID, state
 		}
 	}
 
-	state["schema_version"] = "1.0.0"
-	state["generated_at"] = now
-	if _, ok := state["topology"]; !ok {
-		state["topology"] = map[string]any{"inferred_adjacencies": []map[string]any{}}
-	}
+	state.SchemaVersion = currentSchemaVersion
+	state.GeneratedAt = now
 
-	target["device_type"] = mapDeviceType(deviceType)
-	target["current"] = buildCurrentSnapshot(st, extracted)
-	target["serial_history"] = updateSerialHistory(target["serial_history"], serial, st.IngestID, now)
+	target.DeviceType = mapDeviceType(deviceType)
+	target.Current = snapshot
+	target.SerialHistory = updateSerialHistory(target.SerialHistory, serial, st.IngestID, now)
+	if added {
+		state.Devices.Logical = append(state.Devices.Logical, target)
+	}
+	return target.LogicalDeviceID, state
+}
 
-	arr := make([]any, 0, len(logical))
-	for _, dev := range logical {
-		arr = append(arr, dev)
+// sameSnapshotContent compares two device snapshots ignoring the per-ingest
+// observed_at and source fields, so re-ingesting an unchanged device is a
+// no_change while license, HA or network changes still update state.
+func sameSnapshotContent(a, b *Snapshot) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	strip := func(s Snapshot) string {
+		s.ObservedAt = ""
+		s.Source = SnapshotSource{}
+		raw, err := json.Marshal(s)
+		if err != nil {
+			return ""
+		}
+		return string(raw)
 	}
-	state["devices"].(map[string]any)["logical"] = arr
-	return valueString(target["logical_device_id"], ""), state
+	sa := strip(*a)
+	return sa != "" && sa == strip(*b)
 }
 
 func mapDeviceType(v string) string {
@@ -715,120 +708,126 @@ func mapDeviceType(v string) string {
 	return "firewall"
 }
 
-func updateSerialHistory(existing any, serial, ingestID, now string) []any {
-	arr, _ := existing.([]any)
+func updateSerialHistory(history []SerialHistoryItem, serial, ingestID, now string) []SerialHistoryItem {
+	if history == nil {
+		history = []SerialHistoryItem{}
+	}
 	if serial == "not_found" {
-		return arr
+		return history
 	}
-	for i, it := range arr {
-		m, _ := it.(map[string]any)
-		if m == nil {
-			continue
-		}
-		if valueString(m["serial"], "") == serial {
-			m["last_seen_ingest_id"] = ingestID
-			m["last_seen_at"] = now
-			arr[i] = m
-			return arr
+	for i := range history {
+		if history[i].Serial == serial {
+			history[i].LastSeenIngestID = ingestID
+			history[i].LastSeenAt = now
+			return history
 		}
 	}
-	arr = append(arr, map[string]any{
-		"serial":               serial,
-		"first_seen_ingest_id": ingestID,
-		"last_seen_ingest_id":  ingestID,
-		"first_seen_at":        now,
-		"last_seen_at":         now,
-	})
-	sort.Slice(arr, func(i, j int) bool {
-		mi, _ := arr[i].(map[string]any)
-		mj, _ := arr[j].(map[string]any)
-		return valueString(mi["serial"], "") < valueString(mj["serial"], "")
+	history = append(history, SerialHistoryItem{
+		Serial:            serial,
+		FirstSeenIngestID: ingestID,
+		LastSeenIngestID:  ingestID,
+		FirstSeenAt:       now,
+		LastSeenAt:        now,
 	})
-	return arr
+	sort.Slice(history, func(i, j int) bool { return history[i].Serial < history[j].Serial })
+	return history
 }
 
-func buildCurrentSnapshot(st *ingestStatus, extracted map[string]any) map[string]any {
+// buildCurrentSnapshot converts the extracted payload into a typed snapshot.
+// Record lists are decoded through JSON so the payload may come straight
+// from extraction or from a runtime file written while awaiting an RMA
+// decision.
+func buildCurrentSnapshot(st *ingestStatus, extracted map[string]any) *Snapshot {
 	deviceType := valueString(extracted["device_type"], "unknown")
-	routesRuntime := toAnySlice(extracted["routes_runtime"])
-	routesConfig := toAnySlice(extracted["routes_config"])
-	connectedCIDRs := make([]string, 0)
-	for _, it := range routesRuntime {
-		r, _ := it.(map[string]any)
-		if valueString(r["reason"], "") == "connected" {
-			connectedCIDRs = append(connectedCIDRs, valueString(r["destination"], ""))
-		}
-	}
-	sort.Strings(connectedCIDRs)
-	snapshot := map[string]any{
-		"observed_at": time.Now().UTC().Format(time.RFC3339),
-		"source": map[string]any{
-			"ingest_id":          st.IngestID,
-			"fingerprint_sha256": st.ArchiveSHA,
-		},
-		"identity": map[string]any{
-			"hostname":      valueString(extracted["hostname"], "not_found"),
-			"model":         valueString(extracted["model"], "not_found"),
-			"serial":        valueString(extracted["serial"], "not_found"),
-			"panos_version": valueString(extracted["panos_version"], "not_found"),
-			"mgmt_ip":       valueString(extracted["mgmt_ip"], "not_found"),
+	snapshot := &Snapshot{
+		ObservedAt: time.Now().UTC().Format(time.RFC3339),
+		Source: SnapshotSource{
+			IngestID:          st.IngestID,
+			FingerprintSHA256: st.ArchiveSHA,
 		},
-		"management": map[string]any{
-			"management_type":  "undetermined",
-			"panorama_servers": []string{},
-			"cloud_mode":       "not_found",
+		Identity: Identity{
+			Hostname:     valueString(extracted["hostname"], "not_found"),
+			Model:        valueString(extracted["model"], "not_found"),
+			Serial:       valueString(extracted["serial"], "not_found"),
+			PanosVersion: valueString(extracted["panos_version"], "not_found"),
+			MgmtIP:       valueString(extracted["mgmt_ip"], "not_found"),
 		},
-		"ha": map[string]any{
-			"enabled": "unknown",
-			"mode":    "not_found",
-			"peer":    "not_found",
+		Management: Management{
+			ManagementType:  "undetermined",
+			PanoramaServers: []string{},
+			CloudMode:       valueString(extracted["cloud_mode"], "not_found"),
 		},
-		"licenses": []any{},
-		"cloud_logging_service_forwarding": map[string]any{
-			"enabled":                              "unknown",
-			"region":                               "not_found",
-			"enhanced_application_logging_enabled": "unknown",
-			"source_path":                          "not_found",
+		HA:           haSnapshot(extracted),
+		FieldSources: fieldSources(extracted),
+		Licenses:     decodeRecords[License](extracted["licenses"]),
+		CloudLogging: CloudLogging{
+			Enabled:                           "unknown",
+			Region:                            "not_found",
+			EnhancedApplicationLoggingEnabled: "unknown",
+			SourcePath:                        "not_found",
 		},
-		"network": map[string]any{
-			"interfaces": []any{map[string]any{
-				"name": "eth0",
-				"type": "ethernet",
-				"layer3_units": []any{map[string]any{
-					"name":     "eth0.0",
-					"ip_cidrs": connectedCIDRs,
-				}},
-			}},
-			"zones":          []any{},
-			"routes_config":  routesConfig,
-			"routes_runtime": routesRuntime,
+		Network: Network{
+			Interfaces:     decodeRecords[Interface](extracted["interfaces"]),
+			Zones:          decodeRecords[Zone](extracted["zones"]),
+			VirtualRouters: decodeRecords[VirtualRouter](extracted["virtual_routers"]),
+			RoutesConfig:   decodeRecords[Route](extracted["routes_config"]),
+			RoutesRuntime:  decodeRecords[Route](extracted["routes_runtime"]),
 		},
 	}
 	if deviceType == "panorama" {
-		mds, _ := extracted["managed_device_serials"].([]string)
-		snapshot["panorama"] = map[string]any{
-			"managed_device_serials": mds,
-			"device_groups":          []any{},
-			"template_stacks":        []any{},
-			"templates":              []any{},
+		snapshot.Panorama = &PanoramaInfo{
+			ManagedDeviceSerials: decodeRecords[string](extracted["managed_device_serials"]),
+			DeviceGroups:         []string{},
+			TemplateStacks:       []string{},
+			Templates:            []string{},
 		}
 	}
 	return snapshot
 }
 
-func (a *app) sortState(state map[string]any) {
-	logical := logicalDevices(state)
+func haSnapshot(extracted map[string]any) HAInfo {
+	ha, _ := extracted["ha"].(map[string]any)
+	get := func(k string) string { return valueString(ha[k], "not_found") }
+	return HAInfo{
+		Mode:             get("mode"),
+		Peer:             get("peer"),
+		PeerBackup:       get("peer_backup"),
+		LocalState:       get("local_state"),
+		PeerState:        get("peer_state"),
+		PeerSerial:       get("peer_serial"),
+		PeerMgmtIP:       get("peer_mgmt_ip"),
+		SourcePath:       get("source_path"),
+		ConfigSourcePath: get("config_source_path"),
+		Enabled:          valueString(ha["enabled"], "unknown"),
+	}
+}
+
+func fieldSources(extracted map[string]any) FieldSources {
+	sources, _ := extracted["field_sources"].(map[string]any)
+	get := func(f string) FieldSource {
+		src, _ := sources[f].(map[string]any)
+		return FieldSource{
+			Section:    valueString(src["section"], "not_found"),
+			SourcePath: valueString(src["source_path"], "not_found"),
+		}
+	}
+	return FieldSources{
+		Hostname:     get("hostname"),
+		Model:        get("model"),
+		Serial:       get("serial"),
+		PanosVersion: get("panos_version"),
+		MgmtIP:       get("mgmt_ip"),
+	}
+}
+
+func (a *app) sortState(state *State) {
+	logical := state.Devices.Logical
 	sort.Slice(logical, func(i, j int) bool {
-		return valueString(logical[i]["logical_device_id"], "") < valueString(logical[j]["logical_device_id"], "")
+		return logical[i].LogicalDeviceID < logical[j].LogicalDeviceID
 	})
-	arr := make([]any, 0, len(logical))
-	for _, dev := range logical {
-		arr = append(arr, dev)
-	}
-	state["devices"].(map[string]any)["logical"] = arr
 }
 
-func (a *app) applyTopology(state map[string]any) {
-	logical := logicalDevices(state)
+func (a *app) applyTopology(state *State) {
 	type routeRecord struct {
 		devID  string
 		dest   string
@@ -840,44 +839,57 @@ func (a *app) applyTopology(state map[string]any) {
 		reason string
 	}
 	routesByDev := map[string][]routeRecord{}
-	for _, dev := range logical {
-		if valueString(dev["device_type"], "firewall") != "firewall" {
+	for _, dev := range state.Devices.Logical {
+		if valueString(dev.DeviceType, "firewall") != "firewall" {
 			continue
 		}
-		cur, _ := dev["current"].(map[string]any)
-		network, _ := cur["network"].(map[string]any)
-		runtime := toAnySlice(network["routes_runtime"])
-		config := toAnySlice(network["routes_config"])
-		chosen := runtime
+		network := dev.snapshot().Network
+		chosen := network.RoutesRuntime
 		if len(chosen) == 0 {
-			chosen = config
+			chosen = network.RoutesConfig
 		}
 		rec := make([]routeRecord, 0, len(chosen))
-		for _, it := range chosen {
-			r, _ := it.(map[string]any)
-			dst := valueString(r["destination"], "")
-			if dst == "" || dst == "0.0.0.0/0" {
+		for _, r := range chosen {
+			if r.Destination == "" || r.Destination == "0.0.0.0/0" {
 				continue
 			}
-			pfx, err := netip.ParsePrefix(dst)
+			pfx, err := netip.ParsePrefix(r.Destination)
 			if err != nil {
 				continue
 			}
 			rec = append(rec, routeRecord{
-				devID:  valueString(dev["logical_device_id"], ""),
+				devID:  dev.LogicalDeviceID,
 				dest:   pfx.String(),
 				prefix: pfx,
-				vr:     valueString(r["vr"], "not_found"),
-				iface:  valueString(r["interface"], "not_found"),
-				zone:   valueString(r["zone"], "not_found"),
-				source: valueString(r["source_type"], "runtime"),
-				reason: valueString(r["reason"], "unknown"),
+				vr:     valueString(r.VR, "not_found"),
+				iface:  valueString(r.Interface, "not_found"),
+				zone:   valueString(r.Zone, "not_found"),
+				source: valueString(r.SourceType, "runtime"),
+				reason: valueString(r.Reason, "unknown"),
 			})
 		}
-		routesByDev[valueString(dev["logical_device_id"], "")] = rec
+		routesByDev[dev.LogicalDeviceID] = rec
 	}
 
-	edges := make([]map[string]any, 0)
+	haGroups := deriveHAGroups(state.Devices.Logical)
+	haPeer := map[string]string{}
+	for _, g := range haGroups {
+		ids := g.LogicalDeviceIDs
+		haPeer[ids[0]] = ids[1]
+		haPeer[ids[1]] = ids[0]
+	}
+
+	evidenceOf := func(r routeRecord) RouteEvidence {
+		return RouteEvidence{
+			Dest:         r.dest,
+			VR:           r.vr,
+			Interface:    r.iface,
+			Zone:         r.zone,
+			SourceType:   r.source,
+			SourceReason: r.reason,
+		}
+	}
+	edges := make([]Adjacency, 0)
 	devIDs := make([]string, 0, len(routesByDev))
 	for id := range routesByDev {
 		devIDs = append(devIDs, id)
@@ -887,8 +899,12 @@ func (a *app) applyTopology(state map[string]any) {
 		for j := i + 1; j < len(devIDs); j++ {
 			aID := devIDs[i]
 			bID := devIDs[j]
+			if haPeer[aID] == bID {
+				// HA members share a routing table by design; that is not adjacency.
+				continue
+			}
 			bestBits := -1
-			evidence := make([]map[string]any, 0)
+			evidence := make([]AdjacencyEvidence, 0)
 			overlaps := make([]string, 0)
 			for _, ri := range routesByDev[aID] {
 				for _, rj := range routesByDev[bID] {
@@ -899,29 +915,15 @@ func (a *app) applyTopology(state map[string]any) {
 					if rj.prefix.Bits() < bits {
 						bits = rj.prefix.Bits()
 					}
-					ev := map[string]any{
-						"cidr_i": ri.dest,
-						"cidr_j": rj.dest,
-						"fw_i": map[string]any{
-							"dest":          ri.dest,
-							"vr":            ri.vr,
-							"interface":     ri.iface,
-							"zone":          ri.zone,
-							"source_type":   ri.source,
-							"source_reason": ri.reason,
-						},
-						"fw_j": map[string]any{
-							"dest":          rj.dest,
-							"vr":            rj.vr,
-							"interface":     rj.iface,
-							"zone":          rj.zone,
-							"source_type":   rj.source,
-							"source_reason": rj.reason,
-						},
+					ev := AdjacencyEvidence{
+						CIDRI: ri.dest,
+						CIDRJ: rj.dest,
+						FwI:   evidenceOf(ri),
+						FwJ:   evidenceOf(rj),
 					}
 					if bits > bestBits {
 						bestBits = bits
-						evidence = []map[string]any{ev}
+						evidence = []AdjacencyEvidence{ev}
 						overlaps = []string{ri.dest, rj.dest}
 					} else if bits == bestBits {
 						evidence = append(evidence, ev)
@@ -933,23 +935,93 @@ func (a *app) applyTopology(state map[string]any) {
 				continue
 			}
 			sort.Strings(overlaps)
-			edges = append(edges, map[string]any{
-				"fw_a_logical_device_id": aID,
-				"fw_b_logical_device_id": bID,
-				"overlap_cidrs":          uniqueStrings(overlaps),
-				"evidence":               evidence,
+			edges = append(edges, Adjacency{
+				FwALogicalDeviceID: aID,
+				FwBLogicalDeviceID: bID,
+				OverlapCIDRs:       uniqueStrings(overlaps),
+				Evidence:           evidence,
 			})
 		}
 	}
 	sort.Slice(edges, func(i, j int) bool {
-		aA := valueString(edges[i]["fw_a_logical_device_id"], "")
-		bA := valueString(edges[j]["fw_a_logical_device_id"], "")
-		if aA != bA {
-			return aA < bA
+		if edges[i].FwALogicalDeviceID != edges[j].FwALogicalDeviceID {
+			return edges[i].FwALogicalDeviceID < edges[j].FwALogicalDeviceID
 		}
-		return valueString(edges[i]["fw_b_logical_device_id"], "") < valueString(edges[j]["fw_b_logical_device_id"], "")
+		return edges[i].FwBLogicalDeviceID < edges[j].FwBLogicalDeviceID
 	})
-	state["topology"].(map[string]any)["inferred_adjacencies"] = edges
+	state.Topology.InferredAdjacencies = edges
+	state.Topology.HAGroups = haGroups
+}
+
+// deriveHAGroups pairs firewalls whose HA peer serial (or, failing that, peer
+// management IP) points at another logical device in the environment.
+func deriveHAGroups(logical []*LogicalDevice) []HAGroup {
+	type haDev struct {
+		id, serial, mgmtIP, peerSerial, peerMgmtIP, mode, localState string
+	}
+	devs := make([]haDev, 0, len(logical))
+	for _, dev := range logical {
+		if valueString(dev.DeviceType, "firewall") != "firewall" {
+			continue
+		}
+		cur := dev.snapshot()
+		idn, ha := cur.Identity, cur.HA
+		if valueString(ha.Enabled, "unknown") == "disabled" {
+			continue
+		}
+		devs = append(devs, haDev{
+			id:         dev.LogicalDeviceID,
+			serial:     valueString(idn.Serial, "not_found"),
+			mgmtIP:     stripMask(valueString(idn.MgmtIP, "not_found")),
+			peerSerial: valueString(ha.PeerSerial, "not_found"),
+			peerMgmtIP: stripMask(valueString(ha.PeerMgmtIP, "not_found")),
+			mode:       valueString(ha.Mode, "not_found"),
+			localState: valueString(ha.LocalState, "not_found"),
+		})
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].id < devs[j].id })
+
+	paired := map[string]bool{}
+	groups := make([]HAGroup, 0)
+	for i := range devs {
+		for j := i + 1; j < len(devs); j++ {
+			a, b := devs[i], devs[j]
+			if paired[a.id] || paired[b.id] {
+				continue
+			}
+			matchedBy := ""
+			switch {
+			case (a.peerSerial != "not_found" && a.peerSerial == b.serial) || (b.peerSerial != "not_found" && b.peerSerial == a.serial):
+				matchedBy = "peer_serial"
+			case (a.peerMgmtIP != "not_found" && a.peerMgmtIP == b.mgmtIP) || (b.peerMgmtIP != "not_found" && b.peerMgmtIP == a.mgmtIP):
+				matchedBy = "peer_mgmt_ip"
+			default:
+				continue
+			}
+			mode := a.mode
+			if mode == "not_found" {
+				mode = b.mode
+			}
+			paired[a.id], paired[b.id] = true, true
+			groups = append(groups, HAGroup{
+				LogicalDeviceIDs: []string{a.id, b.id},
+				Mode:             mode,
+				MatchedBy:        matchedBy,
+				Members: []HAGroupMember{
+					{LogicalDeviceID: a.id, Serial: a.serial, LocalState: a.localState},
+					{LogicalDeviceID: b.id, Serial: b.serial, LocalState: b.localState},
+				},
+			})
+		}
+	}
+	return groups
+}
+
+func stripMask(v string) string {
+	if i := strings.Index(v, "/"); i >= 0 {
+		return v[:i]
+	}
+	return v
 }
 
 func prefixesOverlap(a, b netip.Prefix) bool {
@@ -976,7 +1048,10 @@ func uniqueStrings(in []string) []string {
 	return out
 }
 
-func (a *app) writeStateAtomic(envDir string, state map[string]any) error {
+func (a *app) writeStateAtomic(envDir string, state *State) error {
+	if err := validateState(state); err != nil {
+		return err
+	}
 	path := filepath.Join(envDir, "state.json")
 	bak := filepath.Join(envDir, "state.json.bak")
 	tmp := path + ".tmp"
@@ -1017,24 +1092,51 @@ func (a *app) writeStateAtomic(envDir string, state map[string]any) error {
 	return nil
 }
 
-func (a *app) writeIntro(envDir string, state map[string]any, lastStatus, finishedAt string) {
-	meta, _ := readMeta(filepath.Join(envDir, "meta.json"))
-	logical := logicalDevices(state)
-	firewalls := 0
-	panoramas := 0
-	for _, dev := range logical {
-		if valueString(dev["device_type"], "") == "panorama" {
-			panoramas++
-		} else {
-			firewalls++
+// lastIngestRecord returns the final record of the environment's most
+// recent ingest, or nil when nothing has been ingested.
+func lastIngestRecord(envDir string) map[string]any {
+	b, err := os.ReadFile(filepath.Join(envDir, "ingest.ndjson"))
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		var rec map[string]any
+		if json.Unmarshal([]byte(lines[i]), &rec) == nil {
+			return rec
 		}
 	}
-	text := fmt.Sprintf("# %s\n\nThis is a derived environment snapshot generated at %s for deterministic inspection.\n\nQuick facts\n- logical devices: %d\n- firewalls: %d\n- panoramas: %d\n- last ingest status: %s at %s\n\nWhere to look in state.json\n- devices list: /devices/logical\n- inferred adjacencies: /topology/inferred_adjacencies\n- per-device network inventory: /devices/logical[i]/current/network\n\nAI Agent notes\n- This file is a derived snapshot; consult commits.ndjson for history.\n- Ingest attempts are recorded in ingest.ndjson (including duplicates/errors).\n- TSF bytes are not retained; provenance is tracked by ingest IDs and fingerprints.\n", meta.Name, time.Now().UTC().Format(time.RFC3339), len(logical), firewalls, panoramas, lastStatus, finishedAt)
+	return nil
+}
+
+// lastIngestStatus summarizes lastIngestRecord as intro.md and the
+// summary endpoints report it, with not_found when nothing was ingested.
+func lastIngestStatus(envDir string) lastIngestSummary {
+	out := lastIngestSummary{Status: "not_found", FinishedAt: "not_found"}
+	if rec := lastIngestRecord(envDir); rec != nil {
+		out.Status = valueString(rec["status"], "not_found")
+		out.FinishedAt = valueString(rec["finished_at"], "not_found")
+	}
+	return out
+}
+
+// refreshIntro rewrites intro.md for state outside an ingest, reporting the
+// most recent ingest from the log.
+func (a *app) refreshIntro(envDir string, state *State) {
+	last := lastIngestStatus(envDir)
+	a.writeIntro(envDir, state, last.Status, last.FinishedAt)
+}
+
+func (a *app) writeIntro(envDir string, state *State, lastStatus, finishedAt string) {
+	meta, _ := readMeta(filepath.Join(envDir, "meta.json"))
+	logical := state.Devices.Logical
+	counts := countDeviceTypes(logical)
+	text := fmt.Sprintf("# %s\n\nThis is a derived environment snapshot generated at %s for deterministic inspection.\n\nQuick facts\n- logical devices: %d\n- firewalls: %d\n- panoramas: %d\n- last ingest status: %s at %s\n\nWhere to look in state.json\n- devices list: /devices/logical\n- inferred adjacencies: /topology/inferred_adjacencies\n- per-device network inventory: /devices/logical[i]/current/network\n\nAI Agent notes\n- This file is a derived snapshot; consult commits.ndjson for history.\n- Ingest attempts are recorded in ingest.ndjson (including duplicates/errors).\n- TSF bytes are not retained; provenance is tracked by ingest IDs and fingerprints.\n", meta.Name, time.Now().UTC().Format(time.RFC3339), counts.Total, counts.Firewalls, counts.Panoramas, lastStatus, finishedAt)
 	text = text + "\n"
 	_ = writeFileAtomic(filepath.Join(envDir, "intro.md"), []byte(text))
 }
 
-func hashCanonical(state map[string]any) (string, error) {
+func hashCanonical(state any) (string, error) {
 	b, err := json.Marshal(state)
 	if err != nil {
 		return "", err
@@ -1046,9 +1148,15 @@ func hashCanonical(state map[string]any) (string, error) {
 func (a *app) writeRuntimeIngest(st *ingestStatus, candidates []map[string]any) error {
 	path := filepath.Join(a.storage, "runtime", "ingests", st.IngestID+".json")
 	payload := map[string]any{
-		"ingest_id":  st.IngestID,
-		"env_id":     st.EnvID,
-		"started_at": st.StartedAt.Format(time.RFC3339),
+		"ingest_id":            st.IngestID,
+		"env_id":               st.EnvID,
+		"started_at":           st.StartedAt.Format(time.RFC3339),
+		"stage_started_at":     st.StageStart.Format(time.RFC3339Nano),
+		"filename":             st.Filename,
+		"fingerprint_sha256":   st.ArchiveSHA,
+		"source_mode":          st.SourceMode,
+		"duration_ms_by_stage": st.Durations,
+		"warnings":             st.Warnings,
 		"device_identity": map[string]any{
 			"hostname": valueString(st.Extracted["hostname"], "not_found"),
 			"serial":   valueString(st.Extracted["serial"], "not_found"),
@@ -1129,11 +1237,6 @@ func (a *app) getIngest(ingestID string) (ingestStatus, bool) {
 	return cp, true
 }
 
-func hashBytes(b []byte) string {
-	sum := sha256.Sum256(b)
-	return hex.EncodeToString(sum[:])
-}
-
 func writeNDJSONLine(path string, v any) error {
 	raw, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxEnvLabels          = 64
+	maxEnvLabelKeyLength  = 63
+	maxEnvLabelValueBytes = 256
+)
+
+var envLabelKey = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// validateLabels checks an environment's label set: keys are short
+// identifiers such as "customer" or "tier", values are free-form text.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxEnvLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxEnvLabels)
+	}
+	for k, v := range labels {
+		if len(k) > maxEnvLabelKeyLength || !envLabelKey.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if len(v) > maxEnvLabelValueBytes {
+			return fmt.Errorf("label %q value exceeds %d bytes", k, maxEnvLabelValueBytes)
+		}
+	}
+	return nil
+}
+
+// envFilter is the parsed query of GET /api/environments. Every label
+// selector must match; a selector without "=" only requires the key.
+type envFilter struct {
+	labels []labelSelector
+	query  string
+}
+
+type labelSelector struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func parseEnvFilter(labelParams []string, q string) (envFilter, error) {
+	f := envFilter{query: strings.ToLower(strings.TrimSpace(q))}
+	for _, p := range labelParams {
+		key, value, hasValue := strings.Cut(p, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return envFilter{}, fmt.Errorf("invalid label selector %q", p)
+		}
+		f.labels = append(f.labels, labelSelector{key: key, value: value, hasValue: hasValue})
+	}
+	return f, nil
+}
+
+// matches reports whether meta satisfies every label selector and, when q is
+// set, contains it case-insensitively in its name, description, ID or a
+// label value.
+func (f envFilter) matches(meta envMeta) bool {
+	for _, sel := range f.labels {
+		v, ok := meta.Labels[sel.key]
+		if !ok || (sel.hasValue && v != sel.value) {
+			return false
+		}
+	}
+	if f.query == "" {
+		return true
+	}
+	fields := []string{meta.Name, meta.Description, meta.EnvID}
+	for _, v := range meta.Labels {
+		fields = append(fields, v)
+	}
+	for _, s := range fields {
+		if strings.Contains(strings.ToLower(s), f.query) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type licenseRow struct {
+	LogicalDeviceID string `json:"logical_device_id"`
+	Hostname        string `json:"hostname"`
+	Serial          string `json:"serial"`
+	Feature         string `json:"feature"`
+	Description     string `json:"description"`
+	Issued          string `json:"issued"`
+	Expires         string `json:"expires"`
+	ExpiresAt       string `json:"expires_at"`
+	Status          string `json:"status"`
+	DaysRemaining   *int   `json:"days_remaining,omitempty"`
+	expiresAt       time.Time
+}
+
+type licensesResponse struct {
+	EnvID          string       `json:"env_id"`
+	ExpiringWithin string       `json:"expiring_within"`
+	Licenses       []licenseRow `json:"licenses"`
+}
+
+var licenseExpiryLayouts = []string{
+	"January 02, 2006",
+	"January 2, 2006",
+	"Jan 02, 2006",
+	"Jan 2, 2006",
+	"2006/01/02",
+	"2006-01-02",
+}
+
+// parseLicenseExpiry parses the raw TSF `Expires:` value; perpetual licenses
+// ("Never") and unknown formats report ok=false.
+func parseLicenseExpiry(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range licenseExpiryLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseWindow accepts day counts ("90d") as well as Go durations ("72h").
+func parseWindow(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if strings.HasSuffix(v, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid day window %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid window %q", v)
+	}
+	return d, nil
+}
+
+func (a *app) handleListLicenses(w http.ResponseWriter, r *http.Request, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	within := strings.TrimSpace(r.URL.Query().Get("expiring_within"))
+	var window time.Duration
+	if within != "" {
+		d, err := parseWindow(within)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "expiring_within must look like 90d or 72h")
+			return
+		}
+		window = d
+	}
+
+	if _, err := os.Stat(filepath.Join(envDir, "state.json")); err != nil {
+		writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "environment state not found")
+		return
+	}
+	state, err := a.readState(envDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "state file is invalid")
+		return
+	}
+
+	now := time.Now().UTC()
+	rows := make([]licenseRow, 0)
+	for _, dev := range state.Devices.Logical {
+		cur := dev.snapshot()
+		for _, lic := range cur.Licenses {
+			row := licenseRow{
+				LogicalDeviceID: dev.LogicalDeviceID,
+				Hostname:        valueString(cur.Identity.Hostname, "not_found"),
+				Serial:          valueString(cur.Identity.Serial, "not_found"),
+				Feature:         valueString(lic.Feature, "not_found"),
+				Description:     valueString(lic.Description, "not_found"),
+				Issued:          valueString(lic.Issued, "not_found"),
+				Expires:         valueString(lic.Expires, "not_found"),
+				ExpiresAt:       "not_found",
+				Status:          valueString(lic.Status, "unknown"),
+			}
+			exp, ok := parseLicenseExpiry(row.Expires)
+			if ok {
+				row.expiresAt = exp
+				row.ExpiresAt = exp.Format(time.RFC3339)
+				days := int(exp.Sub(now).Hours() / 24)
+				row.DaysRemaining = &days
+			}
+			if within != "" && (!ok || exp.Before(now) || exp.After(now.Add(window))) {
+				continue
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		ri, rj := rows[i], rows[j]
+		if !ri.expiresAt.Equal(rj.expiresAt) {
+			if ri.expiresAt.IsZero() || rj.expiresAt.IsZero() {
+				return rj.expiresAt.IsZero()
+			}
+			return ri.expiresAt.Before(rj.expiresAt)
+		}
+		if ri.Hostname != rj.Hostname {
+			return ri.Hostname < rj.Hostname
+		}
+		if ri.LogicalDeviceID != rj.LogicalDeviceID {
+			return ri.LogicalDeviceID < rj.LogicalDeviceID
+		}
+		return ri.Feature < rj.Feature
+	})
+
+	writeJSON(w, http.StatusOK, licensesResponse{
+		EnvID:          envID,
+		ExpiringWithin: within,
+		Licenses:       rows,
+	})
+}
@@ -11,10 +11,12 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 const appVersion = "0.1.0"
@@ -39,6 +41,19 @@ type app struct {
 	storage   string
 	mu        sync.RWMutex
 	ingests   map[string]*ingestStatus
+	batches   map[string]*ingestBatch
+
+	queueMu     sync.Mutex
+	queues      map[string]*envQueue
+	workerSlots chan struct{}
+
+	eventsMu   sync.Mutex
+	ingestSubs map[string]map[chan ingestEvent]bool
+
+	migrateMu sync.Mutex
+	metaMu    sync.Mutex
+
+	trashRetention time.Duration
 }
 
 type envMeta struct {
@@ -49,15 +64,31 @@ type envMeta struct {
 	UpdatedAt     string `json:"updated_at"`
 	SoftDeleted   bool   `json:"soft_deleted"`
 	SoftDeletedAt string `json:"soft_deleted_at"`
+
+	Labels     map[string]string `json:"labels,omitempty"`
+	ClonedFrom *envCloneRef      `json:"cloned_from,omitempty"`
 }
 
 type createEnvRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// updateEnvRequest is the PATCH body; omitted fields are left unchanged.
+// labels, when present, replaces the whole label set.
+type updateEnvRequest struct {
+	Name        *string            `json:"name"`
+	Description *string            `json:"description"`
+	Labels      *map[string]string `json:"labels"`
 }
 
+const maxEnvNameLength = 128
+
 type listEnvsResponse struct {
 	Environments []envMeta `json:"environments"`
+	Total        int       `json:"total"`
+	NextOffset   *int      `json:"next_offset,omitempty"`
 }
 
 type deleteEnvResponse struct {
@@ -67,7 +98,9 @@ type deleteEnvResponse struct {
 }
 
 type envStateResponse struct {
-	State any `json:"state"`
+	State     any    `json:"state"`
+	CommitID  string `json:"commit_id,omitempty"`
+	StateHash string `json:"state_hash,omitempty"`
 }
 
 type commitsResponse struct {
@@ -114,8 +147,16 @@ func main() {
 		baseURL:   baseURL,
 		storage:   storageRoot,
 		ingests:   map[string]*ingestStatus{},
+		batches:   map[string]*ingestBatch{},
+
+		queues:      map[string]*envQueue{},
+		workerSlots: make(chan struct{}, ingestWorkerCount()),
+		ingestSubs:  map[string]map[chan ingestEvent]bool{},
+
+		trashRetention: trashRetentionFromEnv(),
 	}
 	a.cleanupRuntimeIngestsTTL()
+	a.rebuildIngestIndex()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", a.route)
 
@@ -133,10 +174,20 @@ func (a *app) route(w http.ResponseWriter, r *http.Request) {
 		a.handleHealth(w, r)
 	case r.URL.Path == "/api/environments":
 		a.handleEnvironments(w, r)
+	case r.URL.Path == "/api/diff":
+		a.handleEnvDiff(w, r)
+	case r.URL.Path == "/api/schema/state":
+		a.handleGetStateSchema(w, r)
+	case r.URL.Path == "/api/summary":
+		a.handleFleetSummary(w, r)
 	case strings.HasPrefix(r.URL.Path, "/api/ingests/"):
 		a.handleIngestByID(w, r)
+	case strings.HasPrefix(r.URL.Path, "/api/batches/"):
+		a.handleBatchByID(w, r)
 	case strings.HasPrefix(r.URL.Path, "/api/environments/"):
 		a.handleEnvironmentByID(w, r)
+	case r.URL.Path == "/api/trash" || strings.HasPrefix(r.URL.Path, "/api/trash/"):
+		a.handleTrash(w, r)
 	default:
 		http.NotFound(w, r)
 	}
@@ -171,12 +222,23 @@ func (a *app) handleEnvironmentByID(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	if len(parts) == 1 {
-		if r.Method != http.MethodDelete {
+	if len(parts) == 1 && parts[0] == "import" {
+		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		a.handleDeleteEnvironment(w, parts[0])
+		a.handleImportEnvironment(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodDelete:
+			a.handleDeleteEnvironment(w, parts[0])
+		case http.MethodPatch:
+			a.handleUpdateEnvironment(w, r, parts[0])
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 		return
 	}
 
@@ -188,6 +250,14 @@ func (a *app) handleEnvironmentByID(w http.ResponseWriter, r *http.Request) {
 		a.handleCreateIngest(w, r, parts[0])
 		return
 	}
+	if len(parts) == 2 && parts[1] == "batches" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleCreateBatch(w, r, parts[0])
+		return
+	}
 	if len(parts) == 2 && parts[1] == "flow-trace" {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -196,6 +266,56 @@ func (a *app) handleEnvironmentByID(w http.ResponseWriter, r *http.Request) {
 		a.handleFlowTrace(w, r, parts[0])
 		return
 	}
+	if len(parts) == 2 && parts[1] == "clone" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleCloneEnvironment(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "export" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleExportEnvironment(w, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "summary" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleGetEnvironmentSummary(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "reprocess" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleReprocess(w, parts[0])
+		return
+	}
+
+	if len(parts) == 4 && parts[1] == "commits" && parts[3] == "revert" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleRevertCommit(w, parts[0], parts[2])
+		return
+	}
+
+	if len(parts) == 3 && parts[1] == "commits" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleGetCommit(w, parts[0], parts[2])
+		return
+	}
 
 	if len(parts) != 2 || r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -204,9 +324,11 @@ func (a *app) handleEnvironmentByID(w http.ResponseWriter, r *http.Request) {
 
 	switch parts[1] {
 	case "state":
-		a.handleGetEnvironmentState(w, parts[0])
+		a.handleGetEnvironmentState(w, r, parts[0])
 	case "commits":
 		a.handleGetEnvironmentCommits(w, parts[0])
+	case "licenses":
+		a.handleListLicenses(w, r, parts[0])
 	default:
 		http.NotFound(w, r)
 	}
@@ -224,6 +346,14 @@ func (a *app) handleIngestByID(w http.ResponseWriter, r *http.Request) {
 		a.handleGetIngestStatus(w, parts[0])
 		return
 	}
+	if (len(parts) == 1 && r.Method == http.MethodDelete) || (len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost) {
+		a.handleCancelIngest(w, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "events" && r.Method == http.MethodGet {
+		a.handleIngestEvents(w, r, parts[0])
+		return
+	}
 	if len(parts) == 2 && parts[1] == "rma-decision" && r.Method == http.MethodPost {
 		a.handleRmaDecision(w, r, parts[0])
 		return
@@ -231,6 +361,19 @@ func (a *app) handleIngestByID(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusMethodNotAllowed)
 }
 
+func (a *app) handleBatchByID(w http.ResponseWriter, r *http.Request) {
+	batchID := strings.TrimPrefix(r.URL.Path, "/api/batches/")
+	if batchID == "" || strings.Contains(batchID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a.handleGetBatch(w, batchID)
+}
+
 func (a *app) handleCreateEnvironment(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
@@ -243,20 +386,28 @@ func (a *app) handleCreateEnvironment(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid JSON body")
 		return
 	}
-	if strings.TrimSpace(req.Name) == "" {
-		writeError(w, http.StatusBadRequest, "ERR_ENV_NAME_REQUIRED", "environment name is required")
+	// Names are only required to be unique on rename; create keeps its
+	// original contract and accepts duplicates.
+	name, nameErr := normalizeEnvName(req.Name)
+	if nameErr != nil {
+		writeError(w, nameErr.Status, nameErr.Code, nameErr.Msg)
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_ENV_LABEL_INVALID", err.Error())
 		return
 	}
 
 	now := time.Now().UTC().Format(time.RFC3339)
 	meta := envMeta{
 		EnvID:         newUUID(),
-		Name:          req.Name,
+		Name:          name,
 		Description:   req.Description,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 		SoftDeleted:   false,
 		SoftDeletedAt: "",
+		Labels:        req.Labels,
 	}
 	envDir := filepath.Join(a.storage, "environments", meta.EnvID)
 	if err := os.MkdirAll(envDir, 0o755); err != nil {
@@ -270,33 +421,82 @@ func (a *app) handleCreateEnvironment(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, meta)
 }
 
+// handleListEnvironments serves GET /api/environments, sorted by name.
+// Optional query parameters: label=key=value or label=key (repeatable, all
+// must match), q (case-insensitive text search), include_deleted=true, and
+// limit/offset for pagination; next_offset is set while more remain.
 func (a *app) handleListEnvironments(w http.ResponseWriter, r *http.Request) {
-	envsRoot := filepath.Join(a.storage, "environments")
-	if err := os.MkdirAll(envsRoot, 0o755); err != nil {
-		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to initialize environment store")
+	query := r.URL.Query()
+	filter, err := parseEnvFilter(query["label"], query.Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", err.Error())
 		return
 	}
+	limit, offset := 0, 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "limit must be a positive integer")
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "offset must be a non-negative integer")
+			return
+		}
+	}
+	roots := []string{"environments"}
+	if query.Get("include_deleted") == "true" {
+		roots = append(roots, "trash")
+	}
 
-	entries, err := os.ReadDir(envsRoot)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environments")
+	envsRoot := filepath.Join(a.storage, "environments")
+	if err := os.MkdirAll(envsRoot, 0o755); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to initialize environment store")
 		return
 	}
 
-	envs := make([]envMeta, 0, len(entries))
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+	envs := make([]envMeta, 0)
+	for _, root := range roots {
+		entries, err := os.ReadDir(filepath.Join(a.storage, root))
+		if err != nil {
+			if root == "trash" && os.IsNotExist(err) {
+				continue
+			}
+			writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environments")
+			return
 		}
-		metaPath := filepath.Join(envsRoot, entry.Name(), "meta.json")
-		meta, ok := readMeta(metaPath)
-		if !ok || meta.SoftDeleted {
-			continue
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			metaPath := filepath.Join(a.storage, root, entry.Name(), "meta.json")
+			meta, ok := readMeta(metaPath)
+			if !ok || (meta.SoftDeleted && root == "environments") || !filter.matches(meta) {
+				continue
+			}
+			envs = append(envs, meta)
 		}
-		envs = append(envs, meta)
 	}
-	sort.Slice(envs, func(i, j int) bool { return envs[i].EnvID < envs[j].EnvID })
-	writeJSON(w, http.StatusOK, listEnvsResponse{Environments: envs})
+	sort.Slice(envs, func(i, j int) bool {
+		ni, nj := strings.ToLower(envs[i].Name), strings.ToLower(envs[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return envs[i].EnvID < envs[j].EnvID
+	})
+
+	resp := listEnvsResponse{Total: len(envs)}
+	if offset > len(envs) {
+		offset = len(envs)
+	}
+	end := len(envs)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+		resp.NextOffset = &end
+	}
+	resp.Environments = envs[offset:end]
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (a *app) handleDeleteEnvironment(w http.ResponseWriter, envID string) {
@@ -337,6 +537,140 @@ func (a *app) handleDeleteEnvironment(w http.ResponseWriter, envID string) {
 	})
 }
 
+func (a *app) handleUpdateEnvironment(w http.ResponseWriter, r *http.Request, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid request body")
+		return
+	}
+	var req updateEnvRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "invalid JSON body")
+		return
+	}
+	if req.Name == nil && req.Description == nil && req.Labels == nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "name, description or labels is required")
+		return
+	}
+	if req.Labels != nil {
+		if err := validateLabels(*req.Labels); err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_ENV_LABEL_INVALID", err.Error())
+			return
+		}
+	}
+
+	// Serialize updates so two renames cannot both pass the uniqueness check.
+	a.metaMu.Lock()
+	defer a.metaMu.Unlock()
+	metaPath := filepath.Join(envDir, "meta.json")
+	meta, ok := readMeta(metaPath)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environment metadata")
+		return
+	}
+	if req.Name != nil {
+		name, nameErr := a.checkEnvName(*req.Name, envID)
+		if nameErr != nil {
+			writeError(w, nameErr.Status, nameErr.Code, nameErr.Msg)
+			return
+		}
+		meta.Name = name
+	}
+	if req.Description != nil {
+		meta.Description = *req.Description
+	}
+	if req.Labels != nil {
+		meta.Labels = *req.Labels
+	}
+	meta.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := writeMeta(metaPath, meta); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to update environment metadata")
+		return
+	}
+
+	// intro.md is titled with meta.Name; environments without state have no
+	// intro yet and get one on their first ingest.
+	if _, err := os.Stat(filepath.Join(envDir, "state.json")); err == nil {
+		if state, err := a.loadState(envDir); err == nil {
+			a.refreshIntro(envDir, state)
+		}
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// envNameError is a rejected environment name and the response it maps to.
+type envNameError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+// normalizeEnvName trims name and checks that it is present and within
+// maxEnvNameLength.
+func normalizeEnvName(name string) (string, *envNameError) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", &envNameError{http.StatusBadRequest, "ERR_ENV_NAME_REQUIRED", "environment name is required"}
+	}
+	if utf8.RuneCountInString(name) > maxEnvNameLength {
+		return "", &envNameError{http.StatusBadRequest, "ERR_ENV_NAME_TOO_LONG", fmt.Sprintf("environment name must be at most %d characters", maxEnvNameLength)}
+	}
+	return name, nil
+}
+
+// checkEnvName is normalizeEnvName plus the uniqueness rule: no other live
+// environment than exceptID (empty for a new one) may use the name.
+// Callers hold metaMu until the name is written so two requests cannot
+// both claim it.
+func (a *app) checkEnvName(name, exceptID string) (string, *envNameError) {
+	name, nameErr := normalizeEnvName(name)
+	if nameErr != nil {
+		return "", nameErr
+	}
+	taken, err := a.envNameTaken(name, exceptID)
+	if err != nil && !os.IsNotExist(err) {
+		return "", &envNameError{http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environments"}
+	}
+	if taken {
+		return "", &envNameError{http.StatusConflict, "ERR_ENV_NAME_CONFLICT", "another environment already uses this name"}
+	}
+	return name, nil
+}
+
+// envNameTaken reports whether a live environment other than exceptID
+// already uses name, compared case-insensitively.
+func (a *app) envNameTaken(name, exceptID string) (bool, error) {
+	envsRoot := filepath.Join(a.storage, "environments")
+	entries, err := os.ReadDir(envsRoot)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == exceptID {
+			continue
+		}
+		meta, ok := readMeta(filepath.Join(envsRoot, entry.Name(), "meta.json"))
+		if !ok || meta.SoftDeleted {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(meta.Name), name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func writeMeta(path string, meta envMeta) error {
 	payload, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
@@ -358,7 +692,7 @@ func readMeta(path string) (envMeta, bool) {
 	return meta, true
 }
 
-func (a *app) handleGetEnvironmentState(w http.ResponseWriter, envID string) {
+func (a *app) handleGetEnvironmentState(w http.ResponseWriter, r *http.Request, envID string) {
 	envDir, status := a.resolveEnvironmentPath(envID)
 	if status != http.StatusOK {
 		if status == http.StatusGone {
@@ -369,15 +703,21 @@ func (a *app) handleGetEnvironmentState(w http.ResponseWriter, envID string) {
 		return
 	}
 
-	statePath := filepath.Join(envDir, "state.json")
-	payload, err := os.ReadFile(statePath)
-	if err != nil {
-		writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "environment state not found")
+	if commitID, at := r.URL.Query().Get("commit"), r.URL.Query().Get("at"); commitID != "" || at != "" {
+		if commitID != "" && at != "" {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "commit and at are mutually exclusive")
+			return
+		}
+		a.handleGetHistoricalState(w, envDir, commitID, at)
 		return
 	}
 
-	var state any
-	if err := json.Unmarshal(payload, &state); err != nil {
+	if _, err := os.Stat(filepath.Join(envDir, "state.json")); err != nil {
+		writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "environment state not found")
+		return
+	}
+	state, err := a.readState(envDir)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "state file is invalid")
 		return
 	}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// currentSchemaVersion is the state.json schema_version this build writes.
+// States with an older version are upgraded by stateMigrations on load.
+const currentSchemaVersion = "1.1.0"
+
+// stateMigration upgrades a decoded state document from one schema version
+// to the next. Apply edits the generic document in place; it must be
+// deterministic so replaying it over the same input yields the same hash.
+type stateMigration struct {
+	From    string
+	To      string
+	Summary string
+	Apply   func(doc map[string]any)
+}
+
+// stateMigrations is ordered; each entry's From is the previous entry's To.
+var stateMigrations = []stateMigration{
+	{
+		From:    "1.0.0",
+		To:      "1.1.0",
+		Summary: "backfill HA, field source, network and topology fields added after 1.0.0",
+		Apply:   migrateState100To110,
+	},
+}
+
+// migrateState100To110 fills in fields that 1.0.0 states written by early
+// builds may lack: the detailed HA block, field_sources, route zone and
+// provenance, virtual_routers, topology.ha_groups and a null Panorama
+// managed_device_serials list. Values that were never observed become
+// not_found/unknown as for a fresh extraction.
+func migrateState100To110(doc map[string]any) {
+	topology := ensureMap(doc, "topology")
+	ensureList(topology, "inferred_adjacencies")
+	ensureList(topology, "ha_groups")
+	devices := ensureMap(doc, "devices")
+	for _, it := range ensureList(devices, "logical") {
+		dev, _ := it.(map[string]any)
+		if dev == nil {
+			continue
+		}
+		ensureList(dev, "serial_history")
+		cur, _ := dev["current"].(map[string]any)
+		if cur == nil {
+			continue
+		}
+		setDefaults(cur, map[string]string{"observed_at": valueString(doc["generated_at"], "not_found")})
+		setDefaults(ensureMap(cur, "identity"), map[string]string{
+			"hostname": "not_found", "model": "not_found", "serial": "not_found", "panos_version": "not_found", "mgmt_ip": "not_found",
+		})
+		setDefaults(ensureMap(cur, "source"), map[string]string{"ingest_id": "not_found", "fingerprint_sha256": "not_found"})
+		mgmt := ensureMap(cur, "management")
+		setDefaults(mgmt, map[string]string{"management_type": "undetermined", "cloud_mode": "not_found"})
+		ensureList(mgmt, "panorama_servers")
+		setDefaults(ensureMap(cur, "ha"), map[string]string{
+			"enabled": "unknown", "mode": "not_found", "peer": "not_found", "peer_backup": "not_found",
+			"local_state": "not_found", "peer_state": "not_found", "peer_serial": "not_found",
+			"peer_mgmt_ip": "not_found", "source_path": "not_found", "config_source_path": "not_found",
+		})
+		sources := ensureMap(cur, "field_sources")
+		for _, f := range []string{"hostname", "model", "serial", "panos_version", "mgmt_ip"} {
+			setDefaults(ensureMap(sources, f), map[string]string{"section": "not_found", "source_path": "not_found"})
+		}
+		setDefaults(ensureMap(cur, "cloud_logging_service_forwarding"), map[string]string{
+			"enabled": "unknown", "region": "not_found", "enhanced_application_logging_enabled": "unknown", "source_path": "not_found",
+		})
+		for _, it := range ensureList(cur, "licenses") {
+			if lic, ok := it.(map[string]any); ok {
+				setDefaults(lic, map[string]string{
+					"feature": "not_found", "description": "not_found", "issued": "not_found",
+					"expires": "not_found", "status": "unknown", "source_path": "not_found",
+				})
+			}
+		}
+
+		network := ensureMap(cur, "network")
+		for _, key := range []string{"routes_runtime", "routes_config"} {
+			sourceType := strings.TrimPrefix(key, "routes_")
+			for _, it := range ensureList(network, key) {
+				r, ok := it.(map[string]any)
+				if !ok {
+					continue
+				}
+				setDefaults(r, map[string]string{"source_type": sourceType})
+				provenance := "local_config"
+				if r["source_type"] == "runtime" {
+					provenance = "runtime_cli"
+				}
+				setDefaults(r, map[string]string{
+					"vr": "not_found", "destination": "not_found", "nexthop": "not_found", "interface": "not_found",
+					"metric": "not_found", "reason": "unknown", "zone": "not_found", "provenance": provenance,
+					"source_path": "not_found",
+				})
+			}
+		}
+		for _, it := range ensureList(network, "interfaces") {
+			iface, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			setDefaults(iface, map[string]string{
+				"name": "not_found", "type": "unknown", "mode": "unknown", "aggregate_group": "not_found",
+				"zone": "not_found", "vr": "not_found", "provenance": "local_config", "source_path": "not_found",
+			})
+			for _, it := range ensureList(iface, "layer3_units") {
+				if unit, ok := it.(map[string]any); ok {
+					setDefaults(unit, map[string]string{"name": "not_found", "tag": "not_found", "zone": "not_found", "vr": "not_found"})
+					ensureList(unit, "ip_cidrs")
+				}
+			}
+		}
+		for _, it := range ensureList(network, "zones") {
+			if zone, ok := it.(map[string]any); ok {
+				setDefaults(zone, map[string]string{
+					"name": "not_found", "type": "unknown", "vsys": "not_found", "provenance": "local_config", "source_path": "not_found",
+				})
+				ensureList(zone, "members")
+			}
+		}
+		for _, it := range ensureList(network, "virtual_routers") {
+			if vr, ok := it.(map[string]any); ok {
+				setDefaults(vr, map[string]string{"name": "not_found", "provenance": "local_config", "source_path": "not_found"})
+				ensureList(vr, "interfaces")
+			}
+		}
+
+		if pano, ok := cur["panorama"].(map[string]any); ok {
+			for _, key := range []string{"managed_device_serials", "device_groups", "template_stacks", "templates"} {
+				ensureList(pano, key)
+			}
+		}
+	}
+}
+
+func ensureMap(m map[string]any, key string) map[string]any {
+	v, ok := m[key].(map[string]any)
+	if !ok {
+		v = map[string]any{}
+		m[key] = v
+	}
+	return v
+}
+
+func ensureList(m map[string]any, key string) []any {
+	v, ok := m[key].([]any)
+	if !ok {
+		v = []any{}
+		m[key] = v
+	}
+	return v
+}
+
+func setDefaults(m map[string]any, defaults map[string]string) {
+	for k, v := range defaults {
+		if s, ok := m[k].(string); !ok || s == "" {
+			m[k] = v
+		}
+	}
+}
+
+type appliedMigration struct {
+	migration stateMigration
+	before    map[string]any
+	after     map[string]any
+}
+
+// migrateStateDoc upgrades raw state JSON to currentSchemaVersion and
+// returns the decoded state together with the intermediate documents of
+// each migration applied, oldest first. Versions newer than this build or
+// without a migration path are errors.
+func migrateStateDoc(b []byte) (*State, []appliedMigration, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, nil, err
+	}
+	applied := make([]appliedMigration, 0)
+	for version := valueString(doc["schema_version"], "1.0.0"); version != currentSchemaVersion; version = valueString(doc["schema_version"], "") {
+		var next *stateMigration
+		for i := range stateMigrations {
+			if stateMigrations[i].From == version {
+				next = &stateMigrations[i]
+				break
+			}
+		}
+		if next == nil {
+			return nil, nil, fmt.Errorf("unsupported state schema_version %q", version)
+		}
+		before, err := canonicalCopy(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		next.Apply(doc)
+		doc["schema_version"] = next.To
+		after, err := canonicalCopy(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		applied = append(applied, appliedMigration{migration: *next, before: before.(map[string]any), after: after.(map[string]any)})
+	}
+	if len(applied) == 0 {
+		state, err := decodeState(b)
+		return state, nil, err
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := decodeState(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, applied, nil
+}
+
+// emptyState is the state of an environment that has no state.json yet.
+func emptyState(envDir string) *State {
+	return &State{
+		SchemaVersion: currentSchemaVersion,
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
+		Env:           StateEnv{EnvID: filepath.Base(envDir), Name: "unknown"},
+		Devices:       StateDevices{Logical: []*LogicalDevice{}},
+		Topology:      StateTopology{InferredAdjacencies: []Adjacency{}, HAGroups: []HAGroup{}},
+	}
+}
+
+// readState reads an environment's state.json for read-only endpoints. An
+// older schema is migrated in memory only; the file and its migration
+// commits are left for the next write path, which goes through loadState.
+func (a *app) readState(envDir string) (*State, error) {
+	b, err := os.ReadFile(filepath.Join(envDir, "state.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return emptyState(envDir), nil
+		}
+		return nil, err
+	}
+	state, _, err := migrateStateDoc(b)
+	return state, err
+}
+
+// loadState reads an environment's state.json for a write path, upgrading
+// and persisting it first when it was written with an older schema. Each
+// applied migration is recorded as its own commit so history shows exactly
+// what changed.
+func (a *app) loadState(envDir string) (*State, error) {
+	path := filepath.Join(envDir, "state.json")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return emptyState(envDir), nil
+		}
+		return nil, err
+	}
+	state, applied, err := migrateStateDoc(b)
+	if err != nil || len(applied) == 0 {
+		return state, err
+	}
+
+	a.migrateMu.Lock()
+	defer a.migrateMu.Unlock()
+	// Another caller may have migrated the file while we waited.
+	if b, err = os.ReadFile(path); err != nil {
+		return nil, err
+	}
+	if state, applied, err = migrateStateDoc(b); err != nil || len(applied) == 0 {
+		return state, err
+	}
+	if err := a.writeStateAtomic(envDir, state); err != nil {
+		return nil, err
+	}
+	envID := filepath.Base(envDir)
+	for _, m := range applied {
+		_, _ = writeStateSnapshot(envDir, m.before)
+		beforeHash, _ := hashCanonical(m.before)
+		afterHash, _ := hashCanonical(m.after)
+		commit := map[string]any{
+			"commit_id":         newUUID(),
+			"env_id":            envID,
+			"ingest_id":         "not_found",
+			"timestamp":         time.Now().UTC().Format(time.RFC3339),
+			"source_summary":    "schema migration " + m.migration.From + " -> " + m.migration.To,
+			"change_summary":    []string{m.migration.Summary},
+			"state_hash_before": beforeHash,
+			"state_hash_after":  afterHash,
+			"schema_migration":  map[string]any{"from": m.migration.From, "to": m.migration.To},
+		}
+		if err := recordStateCommit(envDir, commit, m.before, m.after); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// handleReprocess re-derives device order, inferred adjacencies and HA
+// groups from the stored device snapshots, e.g. after a schema migration. It records a commit only
+// when the derived state differs.
+func (a *app) handleReprocess(w http.ResponseWriter, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+	if !a.claimEnvQueue(envID) {
+		writeError(w, http.StatusConflict, "ERR_ENV_BUSY", "environment has ingests in progress")
+		return
+	}
+	defer a.releaseEnvQueue(envID)
+
+	if _, err := os.Stat(filepath.Join(envDir, "state.json")); err != nil {
+		writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "environment state not found")
+		return
+	}
+	state, err := a.loadState(envDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to load state")
+		return
+	}
+	beforeHash, _ := hashCanonical(state)
+	beforeDoc, _ := canonicalCopy(state)
+	a.sortState(state)
+	a.applyTopology(state)
+	afterHash, _ := hashCanonical(state)
+	if beforeHash == afterHash {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "no_change", "state_hash_after": afterHash})
+		return
+	}
+
+	if err := a.writeStateAtomic(envDir, state); err != nil {
+		writePersistError(w, err)
+		return
+	}
+	_, _ = writeStateSnapshot(envDir, beforeDoc)
+	afterDoc, _ := canonicalCopy(state)
+	commit := map[string]any{
+		"commit_id":         newUUID(),
+		"env_id":            envID,
+		"ingest_id":         "not_found",
+		"timestamp":         time.Now().UTC().Format(time.RFC3339),
+		"source_summary":    "reprocess topology",
+		"state_hash_before": beforeHash,
+		"state_hash_after":  afterHash,
+	}
+	if err := recordStateCommit(envDir, commit, beforeDoc, afterDoc); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to append commit")
+		return
+	}
+
+	a.refreshIntro(envDir, state)
+	writeJSON(w, http.StatusOK, commit)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// state100JSON is a 1.0.0 state as early builds wrote it: no HA block, no
+// field_sources, routes without source_type/zone/provenance and no
+// topology.ha_groups.
+const state100JSON = `{
+  "schema_version": "1.0.0",
+  "generated_at": "2026-01-01T00:00:00Z",
+  "env": {"env_id": "0b7c6f1e-8d7a-4f4e-9a51-3c2d1e0f9a8b", "name": "lab"},
+  "devices": {"logical": [{
+    "logical_device_id": "5f0e2a3b-1c4d-4e5f-8a9b-0c1d2e3f4a5b",
+    "device_type": "firewall",
+    "current": {
+      "source": {"ingest_id": "7d1e9c2a-4b3f-4a5e-9c8d-1e2f3a4b5c6d", "fingerprint_sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+      "identity": {"hostname": "fw1", "serial": "S1"},
+      "ha": {"mode": "active-passive"},
+      "network": {
+        "routes_runtime": [{"destination": "10.0.0.0/24", "interface": "ethernet1/1"}],
+        "routes_config": [{"destination": "10.1.0.0/24", "source_type": "config", "provenance": "panorama_pushed"}]
+      }
+    }
+  }]},
+  "topology": {"inferred_adjacencies": []}
+}`
+
+func TestMigrateStateDoc(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantApplied int
+		wantErr     string
+		check       func(t *testing.T, state *State)
+	}{
+		{
+			name:        "current version passes through",
+			input:       minimalStateJSON,
+			wantApplied: 0,
+		},
+		{
+			name:        "1.0.0 is upgraded",
+			input:       state100JSON,
+			wantApplied: 1,
+			check: func(t *testing.T, state *State) {
+				if err := validateState(state); err != nil {
+					t.Fatalf("migrated state does not match the schema: %v", err)
+				}
+				cur := state.Devices.Logical[0].Current
+				if cur.Identity.Hostname != "fw1" || cur.Identity.Model != "not_found" {
+					t.Errorf("identity = %+v, want hostname kept and model backfilled", cur.Identity)
+				}
+				if cur.HA.Mode != "active-passive" || cur.HA.Enabled != "unknown" {
+					t.Errorf("ha = %+v, want mode kept and enabled backfilled", cur.HA)
+				}
+				if cur.ObservedAt != "2026-01-01T00:00:00Z" {
+					t.Errorf("observed_at = %q, want generated_at", cur.ObservedAt)
+				}
+				rt := cur.Network.RoutesRuntime[0]
+				if rt.SourceType != "runtime" || rt.Provenance != "runtime_cli" || rt.Zone != "not_found" {
+					t.Errorf("runtime route = %+v", rt)
+				}
+				rc := cur.Network.RoutesConfig[0]
+				if rc.SourceType != "config" || rc.Provenance != "panorama_pushed" {
+					t.Errorf("config route = %+v, want existing provenance kept", rc)
+				}
+				if state.Topology.HAGroups == nil {
+					t.Error("topology.ha_groups not backfilled")
+				}
+			},
+		},
+		{
+			name:        "missing source is backfilled with schema-valid values",
+			input:       strings.Replace(state100JSON, `"source": {"ingest_id": "7d1e9c2a-4b3f-4a5e-9c8d-1e2f3a4b5c6d", "fingerprint_sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},`, "", 1),
+			wantApplied: 1,
+			check: func(t *testing.T, state *State) {
+				if err := validateState(state); err != nil {
+					t.Fatalf("migrated state does not match the schema: %v", err)
+				}
+				if src := state.Devices.Logical[0].Current.Source; src.IngestID != "not_found" || src.FingerprintSHA256 != "not_found" {
+					t.Errorf("source = %+v, want not_found", src)
+				}
+			},
+		},
+		{
+			name:        "missing schema_version is treated as 1.0.0",
+			input:       strings.Replace(state100JSON, `"schema_version": "1.0.0",`, "", 1),
+			wantApplied: 1,
+		},
+		{
+			name:    "newer version is rejected",
+			input:   strings.Replace(minimalStateJSON, `"1.1.0"`, `"9.0.0"`, 1),
+			wantErr: `unsupported state schema_version "9.0.0"`,
+		},
+		{
+			name:    "unknown field survives migration and is rejected",
+			input:   strings.Replace(state100JSON, `"generated_at"`, `"extra": 1, "generated_at"`, 1),
+			wantErr: `unknown field "extra"`,
+		},
+		{
+			name:    "not JSON",
+			input:   `{`,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, applied, err := migrateStateDoc([]byte(tc.input))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("migrateStateDoc error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("migrateStateDoc: %v", err)
+			}
+			if len(applied) != tc.wantApplied {
+				t.Fatalf("applied %d migrations, want %d", len(applied), tc.wantApplied)
+			}
+			if state.SchemaVersion != currentSchemaVersion {
+				t.Errorf("schema_version = %q, want %q", state.SchemaVersion, currentSchemaVersion)
+			}
+			for _, m := range applied {
+				if m.before["schema_version"] == m.after["schema_version"] {
+					t.Errorf("migration %s -> %s did not bump schema_version", m.migration.From, m.migration.To)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, state)
+			}
+		})
+	}
+}
+
+func TestMigrateStateDocDeterministic(t *testing.T) {
+	hashes := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		state, _, err := migrateStateDoc([]byte(state100JSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := hashCanonical(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[h] = true
+	}
+	if len(hashes) != 1 {
+		t.Errorf("migration produced %d different hashes, want 1", len(hashes))
+	}
+}
+
+func TestStateMigrationsChain(t *testing.T) {
+	version := stateMigrations[0].From
+	for _, m := range stateMigrations {
+		if m.From != version {
+			t.Fatalf("migration %s -> %s does not follow %s", m.From, m.To, version)
+		}
+		version = m.To
+	}
+	if version != currentSchemaVersion {
+		t.Errorf("migrations end at %s, want %s", version, currentSchemaVersion)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(stateSchemaJSON, &doc); err != nil {
+		t.Fatal(err)
+	}
+	props, _ := doc["properties"].(map[string]any)
+	sv, _ := props["schema_version"].(map[string]any)
+	if sv["const"] != currentSchemaVersion {
+		t.Errorf("state schema pins schema_version %v, want %s", sv["const"], currentSchemaVersion)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// ingestJob is one unit of work for an environment queue: an archive to
+// scan (upload) or a resumed RMA decision. Archives that failed to arrive
+// still queue so their records land in submission order.
+type ingestJob struct {
+	envDir   string
+	st       *ingestStatus
+	upload   string
+	scanErr  *scanError
+	decision map[string]any
+}
+
+type envQueue struct {
+	jobs    []*ingestJob
+	running bool
+}
+
+func ingestWorkerCount() int {
+	n := runtime.NumCPU()
+	if n < 2 {
+		return 2
+	}
+	if n > 8 {
+		return 8
+	}
+	return n
+}
+
+// enqueueIngest appends a job to its environment's FIFO queue. Each
+// environment drains its queue on a single goroutine (D-00012); the shared
+// worker slots bound how many environments ingest at once.
+func (a *app) enqueueIngest(job *ingestJob) {
+	now := time.Now().UTC()
+	job.st.Durations[job.st.Stage] += now.Sub(job.st.StageStart).Milliseconds()
+	job.st.StageStart = now
+
+	a.queueMu.Lock()
+	q := a.queues[job.st.EnvID]
+	if q == nil {
+		q = &envQueue{}
+		a.queues[job.st.EnvID] = q
+	}
+	q.jobs = append(q.jobs, job)
+	start := !q.running
+	q.running = true
+	a.publishQueuePositions(q)
+	a.queueMu.Unlock()
+
+	if start {
+		go a.runEnvQueue(job.st.EnvID)
+	}
+}
+
+func (a *app) runEnvQueue(envID string) {
+	for {
+		a.workerSlots <- struct{}{}
+		a.queueMu.Lock()
+		q := a.queues[envID]
+		if len(q.jobs) == 0 {
+			q.running = false
+			delete(a.queues, envID)
+			a.queueMu.Unlock()
+			<-a.workerSlots
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		a.publishQueuePositions(q)
+		a.queueMu.Unlock()
+
+		// Time spent waiting in the queue counts toward duration_ms_total but
+		// is not attributed to any stage.
+		job.st.Status = "running"
+		job.st.QueuePosition = 0
+		job.st.Progress.Message = "started"
+		job.st.StageStart = time.Now().UTC()
+		a.storeIngest(job.st)
+		a.publishIngestEvent(job.st)
+		switch {
+		case a.abortIfCanceled(job.envDir, job.st, job.st.Extracted):
+		case job.scanErr != nil:
+			a.failScan(job.envDir, job.st, job.scanErr)
+		case job.upload != "":
+			a.scanQueuedIngest(job)
+		default:
+			a.processIngest(job.envDir, job.st, nil, job.decision)
+		}
+		<-a.workerSlots
+	}
+}
+
+// publishQueuePositions must be called with queueMu held.
+func (a *app) publishQueuePositions(q *envQueue) {
+	for i, job := range q.jobs {
+		job.st.Status = "queued"
+		job.st.QueuePosition = i + 1
+		job.st.Progress.Message = "queued"
+		a.storeIngest(job.st)
+		a.publishIngestEvent(job.st)
+	}
+}
+
+// claimEnvQueue marks an idle environment's queue as running so a
+// non-ingest state change such as a revert can run without an ingest
+// interleaving. It reports false when the environment is busy. Ingests
+// submitted while the claim is held wait in the queue until
+// releaseEnvQueue.
+func (a *app) claimEnvQueue(envID string) bool {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	if a.queues[envID] != nil {
+		return false
+	}
+	a.queues[envID] = &envQueue{running: true}
+	return true
+}
+
+func (a *app) releaseEnvQueue(envID string) {
+	a.queueMu.Lock()
+	q := a.queues[envID]
+	if q != nil && len(q.jobs) == 0 {
+		delete(a.queues, envID)
+		q = nil
+	}
+	a.queueMu.Unlock()
+	if q != nil {
+		go a.runEnvQueue(envID)
+	}
+}
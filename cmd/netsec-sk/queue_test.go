@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestApp returns an app wired the way main does, storing under a
+// temporary directory.
+func newTestApp(t *testing.T) *app {
+	t.Helper()
+	return &app{
+		storage:     t.TempDir(),
+		ingests:     map[string]*ingestStatus{},
+		batches:     map[string]*ingestBatch{},
+		queues:      map[string]*envQueue{},
+		workerSlots: make(chan struct{}, 2),
+		ingestSubs:  map[string]map[chan ingestEvent]bool{},
+	}
+}
+
+func newTestEnvDir(t *testing.T, a *app) (string, string) {
+	t.Helper()
+	envID := newUUID()
+	envDir := filepath.Join(a.storage, "environments", envID)
+	if err := os.MkdirAll(envDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return envID, envDir
+}
+
+// waitIngestsCompleted polls the ingest index until every ingest has its
+// final record.
+func waitIngestsCompleted(t *testing.T, a *app, ingestIDs ...string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for _, id := range ingestIDs {
+		for {
+			if st, ok := a.getIngest(id); ok && st.Status == "completed" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("ingest %s did not complete", id)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+}
+
+// ingestLog returns ingest_id and error code of each ingest.ndjson record
+// in file order.
+func ingestLog(t *testing.T, envDir string) []string {
+	t.Helper()
+	recs, err := readCommits(filepath.Join(envDir, "ingest.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, rec := range recs {
+		ingestErr, _ := rec["error"].(map[string]any)
+		out = append(out, valueString(rec["ingest_id"], "")+" "+valueString(ingestErr["code"], ""))
+	}
+	return out
+}
+
+func failingJob(envID, envDir, filename string) *ingestJob {
+	st := newIngestStatus(envID, filename, "upload")
+	return &ingestJob{envDir: envDir, st: st, scanErr: &scanError{Stage: "receive", Code: "ERR_INVALID_ARCHIVE", Msg: "failed to receive upload"}}
+}
+
+func TestEnvQueueRunsInSubmissionOrder(t *testing.T) {
+	a := newTestApp(t)
+	envID, envDir := newTestEnvDir(t, a)
+	if !a.claimEnvQueue(envID) {
+		t.Fatal("claimEnvQueue on an idle environment = false")
+	}
+
+	var ids, want []string
+	for i := 1; i <= 3; i++ {
+		job := failingJob(envID, envDir, fmt.Sprintf("tsf-%d.tgz", i))
+		a.storeIngest(job.st)
+		a.enqueueIngest(job)
+		ids = append(ids, job.st.IngestID)
+		want = append(want, job.st.IngestID+" ERR_INVALID_ARCHIVE")
+	}
+	if a.claimEnvQueue(envID) {
+		t.Error("claimEnvQueue while claimed = true")
+	}
+	for i, id := range ids {
+		st, _ := a.getIngest(id)
+		if st.Status != "queued" || st.QueuePosition != i+1 {
+			t.Errorf("ingest %d status, position = %s, %d; want queued, %d", i+1, st.Status, st.QueuePosition, i+1)
+		}
+	}
+
+	a.releaseEnvQueue(envID)
+	waitIngestsCompleted(t, a, ids...)
+	if got := ingestLog(t, envDir); !reflect.DeepEqual(got, want) {
+		t.Errorf("ingest.ndjson = %q, want %q", got, want)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !a.claimEnvQueue(envID) {
+		if time.Now().After(deadline) {
+			t.Fatal("environment queue still busy after draining")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	a.releaseEnvQueue(envID)
+}
+
+func TestReleaseIdleEnvQueue(t *testing.T) {
+	a := newTestApp(t)
+	envID, _ := newTestEnvDir(t, a)
+	if !a.claimEnvQueue(envID) {
+		t.Fatal("claimEnvQueue on an idle environment = false")
+	}
+	a.releaseEnvQueue(envID)
+	a.queueMu.Lock()
+	q := a.queues[envID]
+	a.queueMu.Unlock()
+	if q != nil {
+		t.Fatalf("queue after release = %+v, want none", q)
+	}
+	if !a.claimEnvQueue(envID) {
+		t.Error("claimEnvQueue after release = false")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// handleRevertCommit serves POST /api/environments/{id}/commits/{commit_id}/revert.
+// The state recorded after the target commit is restored from its snapshot
+// and the revert is appended as a new commit, so history is never rewritten
+// and a revert can itself be reverted.
+func (a *app) handleRevertCommit(w http.ResponseWriter, envID, commitID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+
+	if !a.claimEnvQueue(envID) {
+		writeError(w, http.StatusConflict, "ERR_ENV_BUSY", "an ingest is queued or running for this environment")
+		return
+	}
+	defer a.releaseEnvQueue(envID)
+
+	commits, err := readCommits(filepath.Join(envDir, "commits.ndjson"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read commits")
+		return
+	}
+	var target map[string]any
+	for _, c := range commits {
+		if stringValue(c["commit_id"]) == commitID {
+			target = c
+			break
+		}
+	}
+	if target == nil {
+		writeError(w, http.StatusNotFound, "ERR_COMMIT_NOT_FOUND", "commit not found")
+		return
+	}
+
+	targetHash := stringValue(target["state_hash_after"])
+	restored, err := readStateSnapshot(envDir, targetHash)
+	if err != nil {
+		if errors.Is(err, errSnapshotNotFound) {
+			writeError(w, http.StatusNotFound, "ERR_SNAPSHOT_NOT_FOUND", "no snapshot stored for this commit")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "snapshot file is invalid")
+		return
+	}
+	meta, ok := readMeta(filepath.Join(envDir, "meta.json"))
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environment metadata")
+		return
+	}
+	state, err := a.loadState(envDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to load state")
+		return
+	}
+	// Snapshots taken before a clone or import carry the source
+	// environment's env_id; the restored state belongs to this one. The name
+	// is carried over from the current state so a revert only changes env
+	// when the snapshot came from elsewhere.
+	restored.Env = StateEnv{EnvID: meta.EnvID, Name: state.Env.Name}
+
+	beforeHash, _ := hashCanonical(state)
+	if restoredHash, _ := hashCanonical(restored); beforeHash == restoredHash {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":             "no_change",
+			"reverted_commit_id": commitID,
+			"state_hash_after":   beforeHash,
+		})
+		return
+	}
+	beforeDoc, _ := canonicalCopy(state)
+	if err := a.writeStateAtomic(envDir, restored); err != nil {
+		writePersistError(w, err)
+		return
+	}
+	_, _ = writeStateSnapshot(envDir, beforeDoc)
+	afterHash, _ := hashCanonical(restored)
+
+	afterDoc, _ := canonicalCopy(restored)
+	commit := map[string]any{
+		"commit_id":          newUUID(),
+		"env_id":             envID,
+		"ingest_id":          "not_found",
+		"timestamp":          time.Now().UTC().Format(time.RFC3339),
+		"source_summary":     "revert to commit " + commitID,
+		"state_hash_before":  beforeHash,
+		"state_hash_after":   afterHash,
+		"reverted_commit_id": commitID,
+	}
+	if err := recordStateCommit(envDir, commit, beforeDoc, afterDoc); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to append commit")
+		return
+	}
+
+	a.refreshIntro(envDir, restored)
+	writeJSON(w, http.StatusOK, commit)
+}
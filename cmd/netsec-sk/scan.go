@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxArchiveBytes caps a single TSF upload; real bundles run 300 MB to 1 GB.
+const maxArchiveBytes int64 = 2 << 30
+
+// maxCLISectionBytes bounds how much of one kept CLI section is buffered.
+const maxCLISectionBytes = 64 << 20
+
+// maxCLILineBytes bounds one CLI line; the rest of a longer line is skipped.
+const maxCLILineBytes = 1 << 20
+
+var errArchiveTooLarge = errors.New("archive exceeds upload cap")
+
+var errNoArchiveMembers = errors.New("no files in archive")
+
+// cliSectionsOfInterest are the only CLI sections retained while streaming;
+// everything else in techsupport_*.txt is skipped line by line.
+var cliSectionsOfInterest = []string{
+	"show system info",
+	"show high-availability all",
+	"show interface management",
+	"show routing route",
+	"show advanced-routing route",
+	"request license info",
+}
+
+var managedSerialRe = regexp.MustCompile(`(?i)\bmanaged[_ -]?serial\s*[:=]\s*([A-Za-z0-9._-]+)`)
+
+type tsfScan struct {
+	Members        int
+	CLI            cliSections
+	FallbackCLI    cliSections
+	Configs        map[string]*xmlNode
+	ConfigErrs     map[string]error
+	ManagedSerials []string
+	// Warnings records CLI input that was cut to fit the line and section
+	// caps; it is copied into the ingest record.
+	Warnings []string
+}
+
+type scanError struct {
+	Stage string
+	Code  string
+	Msg   string
+}
+
+// ctxReader stops the upload stream as soon as the ingest is canceled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+type cappedReader struct {
+	r        io.Reader
+	n        int64
+	max      int64
+	exceeded bool
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.n >= c.max {
+		// Probe for one more byte so an upload of exactly max bytes still passes.
+		var one [1]byte
+		n, err := c.r.Read(one[:])
+		if n > 0 {
+			c.exceeded = true
+			return 0, errArchiveTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > c.max-c.n {
+		p = p[:c.max-c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// scanArchive makes the single streaming pass over an uploaded TSF: it
+// hashes every byte, walks the tar members, and parses only candidate CLI
+// and config members. The returned fingerprint is empty when the upload
+// exceeded the cap.
+func scanArchive(ctx context.Context, src io.Reader) (*tsfScan, string, *scanError) {
+	capped := &cappedReader{r: &ctxReader{ctx: ctx, r: src}, max: maxArchiveBytes}
+	hasher := sha256.New()
+	tee := io.TeeReader(capped, hasher)
+	scan := &tsfScan{Configs: map[string]*xmlNode{}, ConfigErrs: map[string]error{}}
+
+	fail := func(code, msg string) (*tsfScan, string, *scanError) {
+		_, _ = io.Copy(io.Discard, tee)
+		if ctx.Err() != nil {
+			return nil, "", &scanError{Stage: "scan", Code: "ERR_USER_ABORTED", Msg: "ingest canceled by user"}
+		}
+		if capped.exceeded {
+			return nil, "", &scanError{Stage: "receive", Code: "ERR_ARCHIVE_TOO_LARGE", Msg: "upload exceeds the archive size cap"}
+		}
+		return nil, hexSum(hasher), &scanError{Stage: "scan", Code: code, Msg: msg}
+	}
+
+	gz, err := gzip.NewReader(tee)
+	if err != nil {
+		return fail("ERR_INVALID_ARCHIVE", "archive is not a readable gzip/tar")
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	managed := map[string]struct{}{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if scan.Members == 0 {
+				return fail("ERR_INVALID_ARCHIVE", "archive is not a readable gzip/tar")
+			}
+			return fail("ERR_ARCHIVE_SCAN_FAILED", "tar listing failed")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		scan.Members++
+		name := hdr.Name
+		switch {
+		case isCLIMember(name), strings.HasSuffix(strings.ToLower(name), ".txt"):
+			secs, warnings, err := parseCLIStream(name, tr, managed)
+			if err != nil {
+				return fail("ERR_ARCHIVE_SCAN_FAILED", "failed to read CLI member "+name)
+			}
+			scan.Warnings = append(scan.Warnings, warnings...)
+			if isCLIMember(name) {
+				scan.CLI = append(scan.CLI, secs...)
+			} else {
+				scan.FallbackCLI = append(scan.FallbackCLI, secs...)
+			}
+		case isConfigMember(name):
+			cfg, err := parseConfigXML(tr)
+			if err != nil {
+				scan.ConfigErrs[name] = err
+				continue
+			}
+			scan.Configs[name] = cfg
+		}
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil && !capped.exceeded && ctx.Err() == nil {
+		return fail("ERR_ARCHIVE_SCAN_FAILED", "failed to read archive trailer")
+	}
+	if capped.exceeded || ctx.Err() != nil {
+		return fail("", "")
+	}
+	if scan.Members == 0 {
+		return nil, hexSum(hasher), &scanError{Stage: "scan", Code: "ERR_INVALID_ARCHIVE", Msg: errNoArchiveMembers.Error()}
+	}
+	if len(scan.CLI) == 0 {
+		scan.CLI = scan.FallbackCLI
+	}
+	scan.FallbackCLI = nil
+	if len(scan.CLI) == 0 && len(scan.Configs) == 0 && len(scan.ConfigErrs) == 0 {
+		return nil, hexSum(hasher), &scanError{Stage: "scan", Code: "ERR_REQUIRED_SOURCE_MISSING", Msg: "no CLI text and no config XML found"}
+	}
+	for s := range managed {
+		scan.ManagedSerials = append(scan.ManagedSerials, s)
+	}
+	sort.Strings(scan.ManagedSerials)
+	return scan, hexSum(hasher), nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isConfigMember(name string) bool {
+	for _, layer := range configLayers {
+		for _, p := range layer.patterns {
+			if matchMemberPattern(p, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCLIStream splits CLI text into `> show ...` sections line by line,
+// keeping only sections in cliSectionsOfInterest. Lines over
+// maxCLILineBytes and sections over maxCLISectionBytes are cut rather than
+// dropping the rest of the member, and each cut is reported as a warning.
+func parseCLIStream(sourcePath string, r io.Reader, managed map[string]struct{}) (cliSections, []string, error) {
+	out := cliSections{}
+	var warnings []string
+	var cur *cliSection
+	var body strings.Builder
+	sectionCut := false
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		if sectionCut {
+			warnings = append(warnings, fmt.Sprintf("%s: section %q truncated at %d bytes", sourcePath, cur.Command, maxCLISectionBytes))
+		}
+		cur.Body = body.String()
+		out = append(out, *cur)
+		body.Reset()
+		cur = nil
+		sectionCut = false
+	}
+	br := bufio.NewReaderSize(r, 64<<10)
+	longLines := 0
+	lineCut := false
+	var buf []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(buf)+len(chunk) <= maxCLILineBytes {
+			buf = append(buf, chunk...)
+		} else {
+			buf = append(buf, chunk[:maxCLILineBytes-len(buf)]...)
+			lineCut = true
+		}
+		if isPrefix {
+			continue
+		}
+		if lineCut {
+			longLines++
+			lineCut = false
+		}
+		line := strings.TrimRight(string(buf), "\r")
+		buf = buf[:0]
+		if m := cliHeaderRe.FindStringSubmatch(line); m != nil {
+			flush()
+			cmd := normalizeCLICommand(m[1])
+			if isCLISectionOfInterest(cmd) {
+				cur = &cliSection{Command: cmd, SourcePath: sourcePath}
+			}
+			continue
+		}
+		if m := managedSerialRe.FindStringSubmatch(line); m != nil {
+			managed[strings.TrimSpace(m[1])] = struct{}{}
+		}
+		if cur == nil {
+			continue
+		}
+		if body.Len() >= maxCLISectionBytes {
+			sectionCut = true
+			continue
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+	if longLines > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s: %d line(s) truncated at %d bytes", sourcePath, longLines, maxCLILineBytes))
+	}
+	return out, warnings, nil
+}
+
+func isCLISectionOfInterest(cmd string) bool {
+	for _, want := range cliSectionsOfInterest {
+		if cmd == want || strings.HasPrefix(cmd, want+" ") {
+			return true
+		}
+	}
+	return false
+}
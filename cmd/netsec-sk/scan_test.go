@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCappedReader(t *testing.T) {
+	const capBytes = 8
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "empty", size: 0},
+		{name: "under the cap", size: capBytes - 1},
+		{name: "exactly the cap", size: capBytes},
+		{name: "one byte over", size: capBytes + 1, wantErr: true},
+		{name: "far over", size: 4 * capBytes, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &cappedReader{r: bytes.NewReader(make([]byte, tc.size)), max: capBytes}
+			got, err := io.ReadAll(c)
+			if tc.wantErr {
+				if !errors.Is(err, errArchiveTooLarge) || !c.exceeded {
+					t.Fatalf("ReadAll error = %v, exceeded = %v; want errArchiveTooLarge", err, c.exceeded)
+				}
+				if len(got) != capBytes {
+					t.Errorf("read %d bytes before the cap, want %d", len(got), capBytes)
+				}
+				return
+			}
+			if err != nil || c.exceeded {
+				t.Fatalf("ReadAll error = %v, exceeded = %v; want neither", err, c.exceeded)
+			}
+			if len(got) != tc.size {
+				t.Errorf("read %d bytes, want %d", len(got), tc.size)
+			}
+		})
+	}
+}
+
+func TestScanArchive(t *testing.T) {
+	const cliText = "fw1> show system info\nhostname: fw1\n"
+	const txtText = "fw1> show system info\nhostname: from-notes\n"
+	cli := bundleMember{"var/tmp/cli/techsupport_1.txt", []byte(cliText)}
+	notes := bundleMember{"notes/show-system-info.txt", []byte(txtText)}
+	config := bundleMember{"opt/pancfg/mgmt/saved-configs/running-config.xml", []byte(localConfigXML)}
+
+	tests := []struct {
+		name        string
+		archive     []byte
+		canceled    bool
+		wantCode    string
+		wantSources []string
+		wantConfigs int
+		wantErrs    int
+	}{
+		{
+			name:        "techsupport CLI wins over other text files",
+			archive:     writeTestBundle(t, []bundleMember{notes, cli, config}),
+			wantSources: []string{cli.name},
+			wantConfigs: 1,
+		},
+		{
+			name:        "other text files are the CLI fallback",
+			archive:     writeTestBundle(t, []bundleMember{notes}),
+			wantSources: []string{notes.name},
+		},
+		{
+			name:        "config alone is enough",
+			archive:     writeTestBundle(t, []bundleMember{config}),
+			wantSources: []string{},
+			wantConfigs: 1,
+		},
+		{
+			name:        "unparsable config is recorded, not fatal",
+			archive:     writeTestBundle(t, []bundleMember{{config.name, []byte("<config><devices>")}}),
+			wantSources: []string{},
+			wantErrs:    1,
+		},
+		{
+			name:     "no CLI text and no config",
+			archive:  writeTestBundle(t, []bundleMember{{"var/log/messages", []byte("boot\n")}}),
+			wantCode: "ERR_REQUIRED_SOURCE_MISSING",
+		},
+		{
+			name:     "empty tar",
+			archive:  writeTestBundle(t, nil),
+			wantCode: "ERR_INVALID_ARCHIVE",
+		},
+		{
+			name:     "not gzip",
+			archive:  []byte(cliText),
+			wantCode: "ERR_INVALID_ARCHIVE",
+		},
+		{
+			name:     "canceled while scanning",
+			archive:  writeTestBundle(t, []bundleMember{cli}),
+			canceled: true,
+			wantCode: "ERR_USER_ABORTED",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.canceled {
+				cancel()
+			}
+			scan, fingerprint, serr := scanArchive(ctx, bytes.NewReader(tc.archive))
+
+			sum := sha256.Sum256(tc.archive)
+			wantFingerprint := hex.EncodeToString(sum[:])
+			if tc.canceled {
+				wantFingerprint = ""
+			}
+			if fingerprint != wantFingerprint {
+				t.Errorf("fingerprint = %q, want %q", fingerprint, wantFingerprint)
+			}
+			if tc.wantCode != "" {
+				if serr == nil || serr.Code != tc.wantCode {
+					t.Fatalf("scanArchive error = %+v, want %s", serr, tc.wantCode)
+				}
+				return
+			}
+			if serr != nil {
+				t.Fatalf("scanArchive: %+v", serr)
+			}
+			sources := []string{}
+			for _, s := range scan.CLI {
+				sources = append(sources, s.SourcePath)
+			}
+			if !reflect.DeepEqual(sources, tc.wantSources) {
+				t.Errorf("CLI sources = %q, want %q", sources, tc.wantSources)
+			}
+			if scan.FallbackCLI != nil {
+				t.Errorf("FallbackCLI = %v, want it cleared", scan.FallbackCLI)
+			}
+			if len(scan.Configs) != tc.wantConfigs || len(scan.ConfigErrs) != tc.wantErrs {
+				t.Errorf("configs, config errors = %d, %d; want %d, %d", len(scan.Configs), len(scan.ConfigErrs), tc.wantConfigs, tc.wantErrs)
+			}
+			if len(scan.CLI) > 0 && !strings.Contains(scan.CLI[0].Body, "hostname:") {
+				t.Errorf("CLI body = %q", scan.CLI[0].Body)
+			}
+		})
+	}
+}
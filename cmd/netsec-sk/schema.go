@@ -0,0 +1,233 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stateSchemaJSON is the JSON Schema for state.json (§9.7). It is the
+// contract agents reading state.json rely on, so every write is checked
+// against it and the same bytes are served at GET /api/schema/state.
+//
+//go:embed state.schema.json
+var stateSchemaJSON []byte
+
+var (
+	stateSchemaOnce sync.Once
+	stateSchema     map[string]any
+	schemaPatterns  sync.Map
+)
+
+type schemaViolation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// stateInvalidError reports a state document that does not satisfy the
+// state schema; it is surfaced as ERR_STATE_INVALID.
+type stateInvalidError struct {
+	Violations []schemaViolation
+}
+
+func (e *stateInvalidError) Error() string {
+	pointers := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		pointers = append(pointers, v.Pointer)
+	}
+	return "state does not match schema at " + strings.Join(pointers, ", ")
+}
+
+// validateState checks state against the embedded schema. The state is
+// round-tripped through JSON first so the check sees exactly the document
+// that would be written, whatever Go types built it.
+func validateState(state any) error {
+	stateSchemaOnce.Do(func() {
+		if err := json.Unmarshal(stateSchemaJSON, &stateSchema); err != nil {
+			panic("invalid embedded state schema: " + err.Error())
+		}
+	})
+	doc, err := canonicalCopy(state)
+	if err != nil {
+		return err
+	}
+	var violations []schemaViolation
+	validateSchemaNode(doc, stateSchema, "", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Pointer < violations[j].Pointer })
+	return &stateInvalidError{Violations: violations}
+}
+
+// validateSchemaNode implements the subset of JSON Schema 2020-12 the state
+// schema uses: $ref to local $defs, type, const, enum, pattern, required,
+// properties, items and if/then.
+func validateSchemaNode(v any, schema map[string]any, ptr string, out *[]schemaViolation) {
+	report := func(msg string) {
+		p := ptr
+		if p == "" {
+			p = "/"
+		}
+		*out = append(*out, schemaViolation{Pointer: p, Message: msg})
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target := resolveSchemaRef(ref)
+		if target == nil {
+			report("unresolvable schema reference " + ref)
+			return
+		}
+		validateSchemaNode(v, target, ptr, out)
+	}
+	if t, ok := schema["type"].(string); ok && jsonTypeOf(v) != t {
+		report(fmt.Sprintf("expected %s, got %s", t, jsonTypeOf(v)))
+		return
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(v, c) {
+		report(fmt.Sprintf("expected %s, got %s", jsonText(c), jsonText(v)))
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report(fmt.Sprintf("value %s is not one of %s", jsonText(v), jsonText(enum)))
+		}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if s, isString := v.(string); isString && !schemaPattern(pattern).MatchString(s) {
+			report(fmt.Sprintf("value %q does not match %s", s, pattern))
+		}
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, r := range required {
+				key, _ := r.(string)
+				if _, present := val[key]; !present {
+					*out = append(*out, schemaViolation{Pointer: ptr + "/" + escapePointer(key), Message: "required property is missing"})
+				}
+			}
+		}
+		if props, ok := schema["properties"].(map[string]any); ok {
+			keys := make([]string, 0, len(props))
+			for k := range props {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				child, present := val[k]
+				sub, _ := props[k].(map[string]any)
+				if present && sub != nil {
+					validateSchemaNode(child, sub, ptr+"/"+escapePointer(k), out)
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, it := range val {
+				validateSchemaNode(it, items, fmt.Sprintf("%s/%d", ptr, i), out)
+			}
+		}
+	}
+	if cond, ok := schema["if"].(map[string]any); ok {
+		var probe []schemaViolation
+		validateSchemaNode(v, cond, ptr, &probe)
+		if then, ok := schema["then"].(map[string]any); ok && len(probe) == 0 {
+			validateSchemaNode(v, then, ptr, out)
+		}
+	}
+}
+
+func resolveSchemaRef(ref string) map[string]any {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var node any = stateSchema
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = m[strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")]
+	}
+	out, _ := node.(map[string]any)
+	return out
+}
+
+func schemaPattern(pattern string) *regexp.Regexp {
+	if re, ok := schemaPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(pattern)
+	schemaPatterns.Store(pattern, re)
+	return re
+}
+
+func jsonText(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func jsonTypeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// writePersistError reports a failed state write: schema violations as
+// ERR_STATE_INVALID with the offending pointers, anything else as
+// ERR_PERSIST_FAILED.
+func writePersistError(w http.ResponseWriter, err error) {
+	var invalid *stateInvalidError
+	if !errors.As(err, &invalid) {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to persist state")
+		return
+	}
+	msg := invalid.Error()
+	if len(msg) > 512 {
+		msg = msg[:512]
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, errorResponse{
+		Code:    "ERR_STATE_INVALID",
+		Message: msg,
+		Details: map[string]any{"violations": invalid.Violations},
+	})
+}
+
+func (a *app) handleGetStateSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(stateSchemaJSON)
+}
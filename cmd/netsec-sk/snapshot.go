@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errSnapshotNotFound = errors.New("state snapshot not found")
+
+// writeStateSnapshot stores the canonical JSON of state under
+// snapshots/<sha256>.json, the same hash commits record as
+// state_hash_before/after, so the file name verifies its content. Existing
+// snapshots are left untouched.
+func writeStateSnapshot(envDir string, state any) (string, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	b = append(b, '\n')
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(envDir, "snapshots", hash+".json")
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	return hash, writeFileAtomic(path, b)
+}
+
+func readStateSnapshot(envDir, hash string) (*State, error) {
+	b, err := os.ReadFile(filepath.Join(envDir, "snapshots", filepath.Base(hash)+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errSnapshotNotFound
+		}
+		return nil, err
+	}
+	// Snapshots keep the schema they were written with; upgrade in memory.
+	state, _, err := migrateStateDoc(b)
+	return state, err
+}
+
+// commitAt returns the last commit at or before t, in log order.
+func commitAt(commits []map[string]any, t time.Time) map[string]any {
+	var found map[string]any
+	var foundAt time.Time
+	for _, c := range commits {
+		ts, err := time.Parse(time.RFC3339, stringValue(c["timestamp"]))
+		if err != nil || ts.After(t) {
+			continue
+		}
+		if found == nil || !ts.Before(foundAt) {
+			found, foundAt = c, ts
+		}
+	}
+	return found
+}
+
+// handleGetHistoricalState serves GET .../state?commit=<id> and ?at=<RFC3339>
+// from the content-addressed snapshots.
+func (a *app) handleGetHistoricalState(w http.ResponseWriter, envDir, commitID, at string) {
+	commits, err := readCommits(filepath.Join(envDir, "commits.ndjson"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read commits")
+		return
+	}
+
+	var commit map[string]any
+	if commitID != "" {
+		for _, c := range commits {
+			if stringValue(c["commit_id"]) == commitID {
+				commit = c
+				break
+			}
+		}
+		if commit == nil {
+			writeError(w, http.StatusNotFound, "ERR_COMMIT_NOT_FOUND", "commit not found")
+			return
+		}
+	} else {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "at must be an RFC3339 timestamp")
+			return
+		}
+		commit = commitAt(commits, t)
+		if commit == nil {
+			writeError(w, http.StatusNotFound, "ERR_ENV_STATE_NOT_FOUND", "no state recorded at or before the requested time")
+			return
+		}
+	}
+
+	hash := stringValue(commit["state_hash_after"])
+	state, err := readStateSnapshot(envDir, hash)
+	if err != nil {
+		if errors.Is(err, errSnapshotNotFound) {
+			writeError(w, http.StatusNotFound, "ERR_SNAPSHOT_NOT_FOUND", "no snapshot stored for this commit")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "snapshot file is invalid")
+		return
+	}
+	writeJSON(w, http.StatusOK, envStateResponse{
+		State:     state,
+		CommitID:  stringValue(commit["commit_id"]),
+		StateHash: hash,
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// State is the typed form of state.json (§9.7). Fields are declared in
+// lexical order of their JSON names so json.Marshal produces the same
+// canonical bytes (§9.7.3) as marshaling the equivalent map.
+type State struct {
+	Devices       StateDevices  `json:"devices"`
+	Env           StateEnv      `json:"env"`
+	GeneratedAt   string        `json:"generated_at"`
+	SchemaVersion string        `json:"schema_version"`
+	Topology      StateTopology `json:"topology"`
+}
+
+type StateDevices struct {
+	Logical []*LogicalDevice `json:"logical"`
+}
+
+type StateEnv struct {
+	EnvID string `json:"env_id"`
+	Name  string `json:"name"`
+}
+
+type StateTopology struct {
+	HAGroups            []HAGroup   `json:"ha_groups"`
+	InferredAdjacencies []Adjacency `json:"inferred_adjacencies"`
+}
+
+type LogicalDevice struct {
+	Current         *Snapshot           `json:"current,omitempty"`
+	DeviceType      string              `json:"device_type"`
+	LogicalDeviceID string              `json:"logical_device_id"`
+	SerialHistory   []SerialHistoryItem `json:"serial_history"`
+}
+
+type SerialHistoryItem struct {
+	FirstSeenAt       string `json:"first_seen_at"`
+	FirstSeenIngestID string `json:"first_seen_ingest_id"`
+	LastSeenAt        string `json:"last_seen_at"`
+	LastSeenIngestID  string `json:"last_seen_ingest_id"`
+	Serial            string `json:"serial"`
+}
+
+// Snapshot is a device's current observed state from its latest ingest.
+type Snapshot struct {
+	CloudLogging CloudLogging   `json:"cloud_logging_service_forwarding"`
+	FieldSources FieldSources   `json:"field_sources"`
+	HA           HAInfo         `json:"ha"`
+	Identity     Identity       `json:"identity"`
+	Licenses     []License      `json:"licenses"`
+	Management   Management     `json:"management"`
+	Network      Network        `json:"network"`
+	ObservedAt   string         `json:"observed_at"`
+	Panorama     *PanoramaInfo  `json:"panorama,omitempty"`
+	Source       SnapshotSource `json:"source"`
+}
+
+type CloudLogging struct {
+	Enabled                           string `json:"enabled"`
+	EnhancedApplicationLoggingEnabled string `json:"enhanced_application_logging_enabled"`
+	Region                            string `json:"region"`
+	SourcePath                        string `json:"source_path"`
+}
+
+type FieldSources struct {
+	Hostname     FieldSource `json:"hostname"`
+	MgmtIP       FieldSource `json:"mgmt_ip"`
+	Model        FieldSource `json:"model"`
+	PanosVersion FieldSource `json:"panos_version"`
+	Serial       FieldSource `json:"serial"`
+}
+
+type FieldSource struct {
+	Section    string `json:"section"`
+	SourcePath string `json:"source_path"`
+}
+
+type HAInfo struct {
+	ConfigSourcePath string `json:"config_source_path"`
+	Enabled          string `json:"enabled"`
+	LocalState       string `json:"local_state"`
+	Mode             string `json:"mode"`
+	Peer             string `json:"peer"`        // HA1 peer-ip from config
+	PeerBackup       string `json:"peer_backup"` // HA1 backup peer-ip from config
+	PeerMgmtIP       string `json:"peer_mgmt_ip"`
+	PeerSerial       string `json:"peer_serial"`
+	PeerState        string `json:"peer_state"`
+	SourcePath       string `json:"source_path"`
+}
+
+type Identity struct {
+	Hostname     string `json:"hostname"`
+	MgmtIP       string `json:"mgmt_ip"`
+	Model        string `json:"model"`
+	PanosVersion string `json:"panos_version"`
+	Serial       string `json:"serial"`
+}
+
+type License struct {
+	Description string `json:"description"`
+	Expires     string `json:"expires"`
+	Feature     string `json:"feature"`
+	Issued      string `json:"issued"`
+	SourcePath  string `json:"source_path"`
+	Status      string `json:"status"`
+}
+
+type Management struct {
+	CloudMode       string   `json:"cloud_mode"`
+	ManagementType  string   `json:"management_type"`
+	PanoramaServers []string `json:"panorama_servers"`
+}
+
+type Network struct {
+	Interfaces     []Interface     `json:"interfaces"`
+	RoutesConfig   []Route         `json:"routes_config"`
+	RoutesRuntime  []Route         `json:"routes_runtime"`
+	VirtualRouters []VirtualRouter `json:"virtual_routers"`
+	Zones          []Zone          `json:"zones"`
+}
+
+type Interface struct {
+	AggregateGroup string       `json:"aggregate_group"`
+	Layer3Units    []Layer3Unit `json:"layer3_units"`
+	Mode           string       `json:"mode"`
+	Name           string       `json:"name"`
+	Provenance     string       `json:"provenance"`
+	SourcePath     string       `json:"source_path"`
+	Type           string       `json:"type"`
+	VR             string       `json:"vr"`
+	Zone           string       `json:"zone"`
+}
+
+type Layer3Unit struct {
+	IPCIDRs []string `json:"ip_cidrs"`
+	Name    string   `json:"name"`
+	Tag     string   `json:"tag"`
+	VR      string   `json:"vr"`
+	Zone    string   `json:"zone"`
+}
+
+// Route is a config static route or a runtime routing-table entry; flags
+// and protocol only exist on runtime routes.
+type Route struct {
+	Destination string `json:"destination"`
+	Flags       string `json:"flags,omitempty"`
+	Interface   string `json:"interface"`
+	Metric      string `json:"metric"`
+	Nexthop     string `json:"nexthop"`
+	Protocol    string `json:"protocol,omitempty"`
+	Provenance  string `json:"provenance"`
+	Reason      string `json:"reason"`
+	SourcePath  string `json:"source_path"`
+	SourceType  string `json:"source_type"`
+	VR          string `json:"vr"`
+	Zone        string `json:"zone"`
+}
+
+type VirtualRouter struct {
+	Interfaces []string `json:"interfaces"`
+	Name       string   `json:"name"`
+	Provenance string   `json:"provenance"`
+	SourcePath string   `json:"source_path"`
+}
+
+type Zone struct {
+	Members    []string `json:"members"`
+	Name       string   `json:"name"`
+	Provenance string   `json:"provenance"`
+	SourcePath string   `json:"source_path"`
+	Type       string   `json:"type"`
+	Vsys       string   `json:"vsys"`
+}
+
+type PanoramaInfo struct {
+	DeviceGroups         []string `json:"device_groups"`
+	ManagedDeviceSerials []string `json:"managed_device_serials"`
+	TemplateStacks       []string `json:"template_stacks"`
+	Templates            []string `json:"templates"`
+}
+
+type SnapshotSource struct {
+	FingerprintSHA256 string `json:"fingerprint_sha256"`
+	IngestID          string `json:"ingest_id"`
+}
+
+type Adjacency struct {
+	Evidence           []AdjacencyEvidence `json:"evidence"`
+	FwALogicalDeviceID string              `json:"fw_a_logical_device_id"`
+	FwBLogicalDeviceID string              `json:"fw_b_logical_device_id"`
+	OverlapCIDRs       []string            `json:"overlap_cidrs"`
+}
+
+type AdjacencyEvidence struct {
+	CIDRI string        `json:"cidr_i"`
+	CIDRJ string        `json:"cidr_j"`
+	FwI   RouteEvidence `json:"fw_i"`
+	FwJ   RouteEvidence `json:"fw_j"`
+}
+
+type RouteEvidence struct {
+	Dest         string `json:"dest"`
+	Interface    string `json:"interface"`
+	SourceReason string `json:"source_reason"`
+	SourceType   string `json:"source_type"`
+	VR           string `json:"vr"`
+	Zone         string `json:"zone"`
+}
+
+type HAGroup struct {
+	LogicalDeviceIDs []string        `json:"logical_device_ids"`
+	MatchedBy        string          `json:"matched_by"`
+	Members          []HAGroupMember `json:"members"`
+	Mode             string          `json:"mode"`
+}
+
+type HAGroupMember struct {
+	LocalState      string `json:"local_state"`
+	LogicalDeviceID string `json:"logical_device_id"`
+	Serial          string `json:"serial"`
+}
+
+// decodeState parses state JSON strictly: unknown fields and trailing data
+// are errors rather than being silently dropped on the next write.
+func decodeState(b []byte) (*State, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var state State
+	if err := dec.Decode(&state); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after state document")
+	}
+	if state.Devices.Logical == nil {
+		state.Devices.Logical = []*LogicalDevice{}
+	}
+	if state.Topology.InferredAdjacencies == nil {
+		state.Topology.InferredAdjacencies = []Adjacency{}
+	}
+	if state.Topology.HAGroups == nil {
+		state.Topology.HAGroups = []HAGroup{}
+	}
+	return &state, nil
+}
+
+// decodeRecords converts extracted records (built in memory as
+// []map[string]any, or []any after a JSON round trip through the runtime
+// file) into typed records. A missing value yields an empty, non-nil slice.
+func decodeRecords[T any](v any) []T {
+	out := make([]T, 0)
+	if v == nil {
+		return out
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	var decoded []T
+	if json.Unmarshal(raw, &decoded) != nil || decoded == nil {
+		return out
+	}
+	return decoded
+}
+
+func (s *State) deviceByID(id string) *LogicalDevice {
+	for _, dev := range s.Devices.Logical {
+		if dev.LogicalDeviceID == id {
+			return dev
+		}
+	}
+	return nil
+}
+
+// snapshot returns the device's current snapshot, or an empty one for a
+// device that has none yet, so callers can read fields without nil checks.
+func (d *LogicalDevice) snapshot() *Snapshot {
+	if d == nil || d.Current == nil {
+		return &Snapshot{}
+	}
+	return d.Current
+}
+
+// routes returns runtime routes followed by config routes.
+func (n Network) routes() []Route {
+	out := make([]Route, 0, len(n.RoutesRuntime)+len(n.RoutesConfig))
+	out = append(out, n.RoutesRuntime...)
+	return append(out, n.RoutesConfig...)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const minimalStateJSON = `{"devices":{"logical":[]},"env":{"env_id":"e1","name":"lab"},"generated_at":"2026-01-01T00:00:00Z","schema_version":"1.1.0","topology":{"ha_groups":[],"inferred_adjacencies":[]}}`
+
+func TestDecodeState(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "minimal", input: minimalStateJSON},
+		{name: "trailing whitespace", input: minimalStateJSON + "\n\n"},
+		{name: "missing arrays", input: `{"env":{"env_id":"e1","name":"lab"}}`},
+		{
+			name:    "unknown top-level field",
+			input:   strings.Replace(minimalStateJSON, `"generated_at"`, `"extra":1,"generated_at"`, 1),
+			wantErr: `unknown field "extra"`,
+		},
+		{
+			name:    "unknown nested field",
+			input:   `{"devices":{"logical":[{"device_type":"firewall","logical_device_id":"d1","serial_history":[],"current":{"identity":{"hostname":"fw","vendor":"x"}}}]}}`,
+			wantErr: `unknown field "vendor"`,
+		},
+		{
+			name:    "trailing document",
+			input:   minimalStateJSON + `{}`,
+			wantErr: "unexpected data after state document",
+		},
+		{
+			name:    "wrong type",
+			input:   `{"devices":{"logical":{}}}`,
+			wantErr: "cannot unmarshal",
+		},
+		{name: "not JSON", input: `state`, wantErr: "invalid character"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, err := decodeState([]byte(tc.input))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("decodeState error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeState: %v", err)
+			}
+			if state.Devices.Logical == nil || state.Topology.HAGroups == nil || state.Topology.InferredAdjacencies == nil {
+				t.Errorf("decodeState left nil lists: %+v", state)
+			}
+		})
+	}
+}
+
+// TestDecodeStateRoundTrip checks that a canonical document survives decode
+// and re-encode byte for byte, which the content-addressed snapshot hashes
+// depend on.
+func TestDecodeStateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "minimal", input: minimalStateJSON},
+		{
+			name:  "device with serial history",
+			input: `{"devices":{"logical":[{"device_type":"firewall","logical_device_id":"d1","serial_history":[{"first_seen_at":"t1","first_seen_ingest_id":"i1","last_seen_at":"t2","last_seen_ingest_id":"i2","serial":"S1"}]}]},"env":{"env_id":"e1","name":"lab"},"generated_at":"t","schema_version":"1.1.0","topology":{"ha_groups":[],"inferred_adjacencies":[]}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, err := decodeState([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("decodeState: %v", err)
+			}
+			out, err := json.Marshal(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.input {
+				t.Errorf("round trip changed the document:\n got %s\nwant %s", out, tc.input)
+			}
+		})
+	}
+}
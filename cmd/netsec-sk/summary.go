@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStaleDays is the stale_days used when the query omits it.
+const defaultStaleDays = 30
+
+type deviceCounts struct {
+	Total     int `json:"total"`
+	Firewalls int `json:"firewalls"`
+	Panoramas int `json:"panoramas"`
+}
+
+type lastIngestSummary struct {
+	Status     string `json:"status"`
+	FinishedAt string `json:"finished_at"`
+}
+
+type envSummary struct {
+	EnvID         string            `json:"env_id"`
+	Name          string            `json:"name"`
+	Devices       deviceCounts      `json:"devices"`
+	Models        map[string]int    `json:"models"`
+	PanosVersions map[string]int    `json:"panos_versions"`
+	HAPairs       int               `json:"ha_pairs"`
+	Adjacencies   int               `json:"adjacencies"`
+	LastIngest    lastIngestSummary `json:"last_ingest"`
+	StaleDays     int               `json:"stale_days"`
+	StaleDevices  int               `json:"stale_devices"`
+}
+
+type fleetTotals struct {
+	Environments  int            `json:"environments"`
+	Devices       deviceCounts   `json:"devices"`
+	Models        map[string]int `json:"models"`
+	PanosVersions map[string]int `json:"panos_versions"`
+	HAPairs       int            `json:"ha_pairs"`
+	Adjacencies   int            `json:"adjacencies"`
+	StaleDevices  int            `json:"stale_devices"`
+}
+
+type fleetSummaryResponse struct {
+	StaleDays    int          `json:"stale_days"`
+	Totals       fleetTotals  `json:"totals"`
+	Environments []envSummary `json:"environments"`
+}
+
+// countDeviceTypes splits logical devices the way intro.md reports them:
+// anything that is not a Panorama counts as a firewall.
+func countDeviceTypes(logical []*LogicalDevice) deviceCounts {
+	c := deviceCounts{Total: len(logical)}
+	for _, dev := range logical {
+		if dev.DeviceType == "panorama" {
+			c.Panoramas++
+		} else {
+			c.Firewalls++
+		}
+	}
+	return c
+}
+
+func parseStaleDays(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("stale_days")
+	if v == "" {
+		return defaultStaleDays, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// lastSeenBySerial returns, per device serial, the finish time of the
+// latest successful or no_change ingest. A no_change ingest leaves
+// observed_at untouched, so the ingest log is the only record that an
+// unchanged device was re-ingested.
+func lastSeenBySerial(envDir string) map[string]time.Time {
+	out := map[string]time.Time{}
+	f, err := os.Open(filepath.Join(envDir, "ingest.ndjson"))
+	if err != nil {
+		return out
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if json.Unmarshal([]byte(line), &rec) != nil {
+			continue
+		}
+		status := valueString(rec["status"], "")
+		if status != "success" && status != "no_change" {
+			continue
+		}
+		dev, _ := rec["device"].(map[string]any)
+		serial := valueString(dev["serial"], "not_found")
+		at, err := time.Parse(time.RFC3339, valueString(rec["finished_at"], ""))
+		if serial == "not_found" || err != nil {
+			continue
+		}
+		if at.After(out[serial]) {
+			out[serial] = at
+		}
+	}
+	return out
+}
+
+func (a *app) summarizeEnvironment(envDir string, meta envMeta, staleDays int) (envSummary, error) {
+	sum := envSummary{
+		EnvID:         meta.EnvID,
+		Name:          meta.Name,
+		Models:        map[string]int{},
+		PanosVersions: map[string]int{},
+		LastIngest:    lastIngestStatus(envDir),
+		StaleDays:     staleDays,
+	}
+	if _, err := os.Stat(filepath.Join(envDir, "state.json")); err != nil {
+		return sum, nil
+	}
+	state, err := a.readState(envDir)
+	if err != nil {
+		return sum, err
+	}
+
+	logical := state.Devices.Logical
+	sum.Devices = countDeviceTypes(logical)
+	sum.HAPairs = len(state.Topology.HAGroups)
+	sum.Adjacencies = len(state.Topology.InferredAdjacencies)
+	cutoff := time.Now().UTC().AddDate(0, 0, -staleDays)
+	seen := lastSeenBySerial(envDir)
+	for _, dev := range logical {
+		idn := dev.snapshot().Identity
+		sum.Models[valueString(idn.Model, "not_found")]++
+		sum.PanosVersions[valueString(idn.PanosVersion, "not_found")]++
+
+		last, _ := time.Parse(time.RFC3339, dev.snapshot().ObservedAt)
+		for _, h := range dev.SerialHistory {
+			if t := seen[h.Serial]; t.After(last) {
+				last = t
+			}
+		}
+		if last.Before(cutoff) {
+			sum.StaleDevices++
+		}
+	}
+	return sum, nil
+}
+
+func (a *app) handleGetEnvironmentSummary(w http.ResponseWriter, r *http.Request, envID string) {
+	envDir, status := a.resolveEnvironmentPath(envID)
+	if status != http.StatusOK {
+		if status == http.StatusGone {
+			writeError(w, http.StatusNotFound, "ERR_ENV_ALREADY_DELETED", "environment already deleted")
+			return
+		}
+		writeError(w, http.StatusNotFound, "ERR_ENV_NOT_FOUND", "environment not found")
+		return
+	}
+	staleDays, ok := parseStaleDays(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "stale_days must be a positive integer")
+		return
+	}
+	meta, _ := readMeta(filepath.Join(envDir, "meta.json"))
+	sum, err := a.summarizeEnvironment(envDir, meta, staleDays)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to load state")
+		return
+	}
+	writeJSON(w, http.StatusOK, sum)
+}
+
+// handleFleetSummary serves GET /api/summary: one summary per live
+// environment, sorted by name, plus fleet-wide totals. The label and q
+// filters of GET /api/environments narrow the set.
+func (a *app) handleFleetSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	staleDays, ok := parseStaleDays(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", "stale_days must be a positive integer")
+		return
+	}
+	filter, err := parseEnvFilter(r.URL.Query()["label"], r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ERR_BAD_REQUEST", err.Error())
+		return
+	}
+
+	envsRoot := filepath.Join(a.storage, "environments")
+	entries, err := os.ReadDir(envsRoot)
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read environments")
+		return
+	}
+	resp := fleetSummaryResponse{
+		StaleDays:    staleDays,
+		Totals:       fleetTotals{Models: map[string]int{}, PanosVersions: map[string]int{}},
+		Environments: make([]envSummary, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		envDir := filepath.Join(envsRoot, entry.Name())
+		meta, ok := readMeta(filepath.Join(envDir, "meta.json"))
+		if !ok || meta.SoftDeleted || !filter.matches(meta) {
+			continue
+		}
+		sum, err := a.summarizeEnvironment(envDir, meta, staleDays)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to load state for environment "+meta.EnvID)
+			return
+		}
+		resp.Environments = append(resp.Environments, sum)
+
+		t := &resp.Totals
+		t.Environments++
+		t.Devices.Total += sum.Devices.Total
+		t.Devices.Firewalls += sum.Devices.Firewalls
+		t.Devices.Panoramas += sum.Devices.Panoramas
+		t.HAPairs += sum.HAPairs
+		t.Adjacencies += sum.Adjacencies
+		t.StaleDevices += sum.StaleDevices
+		for k, v := range sum.Models {
+			t.Models[k] += v
+		}
+		for k, v := range sum.PanosVersions {
+			t.PanosVersions[k] += v
+		}
+	}
+	sort.Slice(resp.Environments, func(i, j int) bool {
+		ni, nj := strings.ToLower(resp.Environments[i].Name), strings.ToLower(resp.Environments[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return resp.Environments[i].EnvID < resp.Environments[j].EnvID
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
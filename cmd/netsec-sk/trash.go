@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTrashRetention is how long a soft-deleted environment stays in the
+// trash before it can be purged without a confirmation token. Override it
+// with NETSEC_SK_TRASH_RETENTION (a Go duration such as "168h").
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+type trashEntry struct {
+	envMeta
+	PurgeAfter string `json:"purge_after"`
+	PurgeToken string `json:"purge_token"`
+}
+
+type listTrashResponse struct {
+	Environments []trashEntry `json:"environments"`
+}
+
+func trashRetentionFromEnv() time.Duration {
+	if v := strings.TrimSpace(os.Getenv("NETSEC_SK_TRASH_RETENTION")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return defaultTrashRetention
+}
+
+// purgeToken confirms an early purge. It is derived from the deletion so a
+// token read from GET /api/trash stops working once the environment is
+// restored and deleted again.
+func purgeToken(meta envMeta) string {
+	sum := sha256.Sum256([]byte(meta.EnvID + "|" + meta.SoftDeletedAt))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *app) trashEntryFor(meta envMeta) trashEntry {
+	entry := trashEntry{envMeta: meta, PurgeAfter: "not_found", PurgeToken: purgeToken(meta)}
+	if deletedAt, err := time.Parse(time.RFC3339, meta.SoftDeletedAt); err == nil {
+		entry.PurgeAfter = deletedAt.Add(a.trashRetention).UTC().Format(time.RFC3339)
+	}
+	return entry
+}
+
+// readTrashMeta loads the metadata of a trashed environment, requiring the
+// stored env_id to match so only real trash entries are ever moved or
+// removed.
+func (a *app) readTrashMeta(envID string) (string, envMeta, bool) {
+	dir := filepath.Join(a.storage, "trash", envID)
+	meta, ok := readMeta(filepath.Join(dir, "meta.json"))
+	if !ok || meta.EnvID != envID {
+		return "", envMeta{}, false
+	}
+	return dir, meta, true
+}
+
+func (a *app) handleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/trash" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleListTrash(w)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/trash/"), "/")
+	if len(parts) == 0 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handlePurgeTrash(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "restore" {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleRestoreTrash(w, parts[0])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (a *app) handleListTrash(w http.ResponseWriter) {
+	entries, err := os.ReadDir(filepath.Join(a.storage, "trash"))
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to read trash")
+		return
+	}
+	out := make([]trashEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, meta, ok := a.readTrashMeta(entry.Name()); ok {
+			out = append(out, a.trashEntryFor(meta))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].EnvID < out[j].EnvID })
+	writeJSON(w, http.StatusOK, listTrashResponse{Environments: out})
+}
+
+func (a *app) handleRestoreTrash(w http.ResponseWriter, envID string) {
+	a.metaMu.Lock()
+	defer a.metaMu.Unlock()
+	trashDir, meta, ok := a.readTrashMeta(envID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_TRASH_NOT_FOUND", "environment not found in trash")
+		return
+	}
+	envDir := filepath.Join(a.storage, "environments", envID)
+	if _, err := os.Stat(envDir); err == nil {
+		writeError(w, http.StatusConflict, "ERR_ENV_EXISTS", "a live environment with this ID already exists")
+		return
+	}
+	// Another environment may have taken the name while this one was trashed.
+	name, nameErr := a.checkEnvName(meta.Name, envID)
+	if nameErr != nil {
+		writeError(w, nameErr.Status, nameErr.Code, nameErr.Msg)
+		return
+	}
+
+	deleted := meta
+	meta.Name = name
+	meta.SoftDeleted = false
+	meta.SoftDeletedAt = ""
+	meta.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	metaPath := filepath.Join(trashDir, "meta.json")
+	if err := writeMeta(metaPath, meta); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to update environment metadata")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(envDir), 0o755); err != nil {
+		_ = writeMeta(metaPath, deleted)
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to initialize environment store")
+		return
+	}
+	if err := os.Rename(trashDir, envDir); err != nil {
+		_ = writeMeta(metaPath, deleted)
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to move environment out of trash")
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// handlePurgeTrash permanently removes a trashed environment once its
+// retention age has passed, or earlier when ?confirm= carries the
+// environment's purge_token from GET /api/trash.
+func (a *app) handlePurgeTrash(w http.ResponseWriter, r *http.Request, envID string) {
+	a.metaMu.Lock()
+	defer a.metaMu.Unlock()
+	trashDir, meta, ok := a.readTrashMeta(envID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ERR_TRASH_NOT_FOUND", "environment not found in trash")
+		return
+	}
+	entry := a.trashEntryFor(meta)
+	confirmed := r.URL.Query().Get("confirm") == entry.PurgeToken
+	if !confirmed {
+		purgeAfter, err := time.Parse(time.RFC3339, entry.PurgeAfter)
+		if err != nil || time.Now().UTC().Before(purgeAfter) {
+			writeError(w, http.StatusConflict, "ERR_PURGE_NOT_ALLOWED", "environment is within its retention period until "+entry.PurgeAfter+"; pass confirm=<purge_token> to purge now")
+			return
+		}
+	}
+	if err := os.RemoveAll(trashDir); err != nil {
+		writeError(w, http.StatusInternalServerError, "ERR_PERSIST_FAILED", "failed to purge environment")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"env_id": envID, "purged": true})
+}